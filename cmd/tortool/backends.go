@@ -0,0 +1,259 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/filvarga/tortools/config"
+	"github.com/filvarga/tortools/download"
+	"github.com/filvarga/tortools/search"
+)
+
+// Backends are the client instances managed together, a single
+// unnamed backend behaves exactly like tortools always did
+type Backends []download.Client
+
+// NewBackends turns endpoints of the merged config into backends, r
+// provides defaults for settings the endpoints leave out. Without
+// endpoints r is the only backend.
+func NewBackends(m *config.Merged, r download.Rtorrent) Backends {
+	var bs Backends
+
+	for name, e := range m.Endpoints {
+		s := r.Settings
+		s.Name = name
+		s.Categories = e.Categories
+		if len(e.Root) > 0 {
+			s.Root = e.Root
+		}
+		if len(e.Reserve) > 0 {
+			s.Reserve = download.Str2Bytes(e.Reserve, r.Reserve)
+		}
+		if e.MaxActive > 0 {
+			s.MaxActive = e.MaxActive
+		}
+		switch e.Type {
+		case "transmission":
+			bs = append(bs, &download.Transmission{Settings: s,
+				URL: e.URL, User: e.User, Password: e.Password})
+		case "qbittorrent":
+			bs = append(bs, &download.QBittorrent{Settings: s,
+				URL: e.URL, User: e.User, Password: e.Password})
+		default:
+			b := r
+			b.Settings = s
+			b.Host, b.Port = "localhost", 80
+			if len(e.Host) > 0 {
+				b.Host = e.Host
+			}
+			if e.Port > 0 {
+				b.Port = e.Port
+			}
+			bs = append(bs, &b)
+		}
+	}
+	if len(bs) == 0 {
+		return Backends{&r}
+	}
+	sort.Slice(bs, func(i, j int) bool {
+		return bs[i].GetSettings().Name < bs[j].GetSettings().Name
+	})
+	return bs
+}
+
+func (bs Backends) Get(name string) download.Client {
+	for _, b := range bs {
+		if b.GetSettings().Name == name {
+			return b
+		}
+	}
+	return nil
+}
+
+func (bs Backends) ListDownloads(view string) Medias {
+	var ms Medias
+	for _, b := range bs {
+		ms = append(ms, ListDownloads(b, view)...)
+	}
+	return ms
+}
+
+func (bs Backends) FindDownloads(s search.Search, tags []string) Medias {
+	var ms Medias
+	for _, b := range bs {
+		ms = append(ms, FindDownloadsB(b, s, tags)...)
+	}
+	return ms
+}
+
+func (bs Backends) FindFirstDownload(s search.Search, tags []string) *Media {
+	for _, b := range bs {
+		if m := FindFirstDownloadB(b, s, tags); m != nil {
+			return m
+		}
+	}
+	return nil
+}
+
+// FindAll returns matching downloads of every backend followed by
+// matching search results
+func (bs Backends) FindAll(s search.Search, tags []string) Medias {
+	return append(bs.FindDownloads(s, tags), FindTorrentsB(s, tags)...)
+}
+
+func (bs Backends) FindFirst(s search.Search, tags []string) *Media {
+	if m := bs.FindFirstDownload(s, tags); m != nil {
+		return m
+	}
+	ms := convertTorrents(findTorrents(firstOnly(s), tags))
+	if ms = rank(originate(ms, s, tags)); len(ms) > 0 {
+		return &ms[0]
+	}
+	return nil
+}
+
+func category(m *Media) string {
+	if m.origin == nil {
+		return "other"
+	}
+	switch m.origin.Search.Type {
+	case search.TV:
+		return "tv"
+	case search.Movie:
+		return "movie"
+	}
+	return "other"
+}
+
+func (bs Backends) load(b download.Client) int {
+	return len(download.GetTorrents(b, "leeching"))
+}
+
+// Route picks the backend a new download goes to. Backends dedicated
+// to other categories are skipped, backends with enough free space are
+// preferred and among those the least loaded one wins.
+func (bs Backends) Route(m *Media) download.Client {
+	var candidates Backends
+
+	if len(bs) == 1 {
+		return bs[0]
+	}
+
+	size := int64(-1)
+	if m.torrent != nil {
+		size = m.size()
+	}
+
+	cat := category(m)
+	for _, b := range bs {
+		categories := b.GetSettings().Categories
+		if len(categories) == 0 || containsAny(cat, categories) {
+			candidates = append(candidates, b)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = bs
+	}
+
+	best := -1
+	bestLoad := 0
+	bestSpace := false
+	for i, b := range candidates {
+		space := download.HasSpace(b, size)
+		load := bs.load(b)
+		if best < 0 || (space && !bestSpace) ||
+			(space == bestSpace && load < bestLoad) {
+			best, bestLoad, bestSpace = i, load, space
+		}
+	}
+	return candidates[best]
+}
+
+func containsAny(s string, values []string) bool {
+	for _, v := range values {
+		if strings.EqualFold(s, v) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	moveTimeout = 5 * time.Minute
+	movePoll    = 2 * time.Second
+)
+
+// awaitMeta waits up to moveTimeout for the metadata of d
+func awaitMeta(c download.Client, d download.Torrent) download.Torrent {
+	deadline := time.Now().Add(moveTimeout)
+	for d.IsMeta() && time.Now().Before(deadline) {
+		time.Sleep(movePoll)
+		if t := download.GetTorrent(c, d.GetHash()); t != nil {
+			d = t
+		}
+	}
+	return d
+}
+
+// Move hands the download over to another backend, the client there
+// fetches metadata by the info-hash and the trackers of the download
+// and rechecks any data it finds. The payload is not moved. The
+// download is erased from its backend only once the other one has the
+// metadata, its queue entry goes along.
+func (bs Backends) Move(m *Media, to download.Client) (*Media, error) {
+	var trackers []string
+
+	name := to.GetSettings().Name
+
+	if !m.Local {
+		return nil, fmt.Errorf("%s is not a download", m.Name)
+	}
+	if m.Backend == name {
+		return m, nil
+	}
+	hash := m.download.GetHash()
+	if from := bs.Get(m.Backend); from != nil {
+		var err error
+		if trackers, err = from.Trackers(hash); err != nil {
+			log.Println(err)
+		}
+	}
+
+	d := download.AddTorrent(to, download.Magnet(hash, m.Name, trackers), true)
+	if d == nil {
+		return nil, fmt.Errorf("adding %s to %s failed", m.Name, name)
+	}
+	if d = awaitMeta(to, d); d.IsMeta() {
+		return nil, fmt.Errorf("no metadata of %s on %s yet, kept on %s",
+			m.Name, name, m.Backend)
+	}
+	if !m.download.Delete() {
+		log.Printf("erasing %s from %s failed\n", m.Name, m.Backend)
+	}
+	if p, ok := m.download.(*download.Download); ok {
+		log.Printf("payload of %s stays in %s\n", m.Name, p.GetDataPath())
+	}
+	requeue(d, to)
+	retrack(d)
+	return convertDownload(d), nil
+}
+
+/* vim: set ts=2: */
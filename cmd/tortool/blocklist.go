@@ -0,0 +1,158 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/filvarga/tortools/download"
+	"github.com/filvarga/tortools/search"
+	"github.com/filvarga/tortools/store"
+)
+
+// Blocked is a release never to be downloaded again, matched by exactly
+// one of info-hash, release name pattern or release group
+type Blocked struct {
+	Hash    string `json:",omitempty"`
+	Pattern string `json:",omitempty"`
+	Group   string `json:",omitempty"`
+	Name    string `json:",omitempty"`
+	Reason  string
+	Added   time.Time
+	re      *regexp.Regexp
+}
+
+type Blocklist []Blocked
+
+func LoadBlocklist() Blocklist {
+	var bl Blocklist
+	if err := store.Load("blocklist", &bl); err != nil {
+		log.Fatal(err)
+	}
+	for i := range bl {
+		bl[i].compile()
+	}
+	return bl
+}
+
+// compile prepares the pattern, patterns ignore case
+func (b *Blocked) compile() error {
+	if len(b.Pattern) == 0 {
+		return nil
+	}
+	re, err := regexp.Compile("(?i)" + b.Pattern)
+	if err != nil {
+		return err
+	}
+	b.re = re
+	return nil
+}
+
+func (bl Blocklist) Save() {
+	if err := store.Save("blocklist", bl); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (b *Blocked) String() string {
+	switch {
+	case len(b.Pattern) > 0:
+		return fmt.Sprintf("pattern: %s (%s)", b.Pattern, b.Reason)
+	case len(b.Group) > 0:
+		return fmt.Sprintf("group:   %s (%s)", b.Group, b.Reason)
+	default:
+		return fmt.Sprintf("hash:    %s %s (%s)", b.Hash, b.Name, b.Reason)
+	}
+}
+
+func (bl Blocklist) Show() {
+	for i, b := range bl {
+		fmt.Printf("%3d %s\n", i+1, b.String())
+	}
+}
+
+func (bl Blocklist) Add(b Blocked) (Blocklist, error) {
+	if err := b.compile(); err != nil {
+		return bl, err
+	}
+	for _, o := range bl {
+		if o.Hash == b.Hash && o.Pattern == b.Pattern &&
+			strings.EqualFold(o.Group, b.Group) {
+			return bl, nil
+		}
+	}
+	b.Added = time.Now()
+	return append(bl, b), nil
+}
+
+// Remove drops the entry at position i (1 based)
+func (bl Blocklist) Remove(i int) (Blocklist, error) {
+	if i < 1 || i > len(bl) {
+		return bl, fmt.Errorf("invalid blocklist position")
+	}
+	return append(bl[:i-1:i-1], bl[i:]...), nil
+}
+
+func Block(hash string, name string, reason string) {
+	bl, _ := LoadBlocklist().Add(Blocked{
+		Hash:   hash,
+		Name:   name,
+		Reason: reason,
+	})
+	bl.Save()
+}
+
+var reGroup = regexp.MustCompile(`-(?P<group>[A-Za-z0-9]+)(?:\s*\[[^\]]*\])?(?:\.[a-z0-9]{2,4})?\s*$`)
+
+// releaseGroup returns the group suffix of a scene release name, for
+// instance "GRP" for "Show.S01E01.720p.HDTV.x264-GRP[eztv]"
+func releaseGroup(name string) string {
+	match := reGroup.FindStringSubmatch(name)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+func (b *Blocked) blocks(hash string, name string) bool {
+	switch {
+	case len(b.Pattern) > 0:
+		return b.re != nil && b.re.MatchString(name)
+	case len(b.Group) > 0:
+		return strings.EqualFold(b.Group, releaseGroup(name))
+	default:
+		return len(hash) > 0 && b.Hash == hash
+	}
+}
+
+func (bl Blocklist) isBlocked(hash string, name string) bool {
+	for i := range bl {
+		if bl[i].blocks(hash, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (bl Blocklist) accepts(t search.Torrent) bool {
+	return !bl.isBlocked(download.MagnetHash(t.Magnet), t.Title)
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,236 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/filvarga/tortools/download"
+	"github.com/filvarga/tortools/extract"
+	"github.com/filvarga/tortools/search"
+	"github.com/filvarga/tortools/store"
+	"github.com/filvarga/tortools/verify"
+)
+
+// Staged is a payload whose archives were extracted for the importer
+type Staged struct {
+	Path  string
+	Added time.Time
+}
+
+type Stageds []Staged
+
+func loadStaged() Stageds {
+	var ss Stageds
+	if err := store.Load("staged", &ss); err != nil {
+		log.Fatal(err)
+	}
+	return ss
+}
+
+func (ss Stageds) save() {
+	if err := store.Save("staged", ss); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func stage(path string) {
+	ss := loadStaged()
+	for _, s := range ss {
+		if s.Path == path {
+			return
+		}
+	}
+	append(ss, Staged{Path: path, Added: time.Now()}).save()
+}
+
+// SweepStaging removes staging directories once their files are
+// imported, or not imported for keep (zero keeps them until then)
+func (dm *Daemon) SweepStaging() {
+	var keep Stageds
+
+	ss := loadStaged()
+	for _, s := range ss {
+		if _, err := os.Stat(extract.Staging(s.Path)); os.IsNotExist(err) {
+			continue
+		}
+		imported := extract.Imported(s.Path)
+		if !imported && (dm.StagingKeep <= 0 || time.Since(s.Added) < dm.StagingKeep) {
+			keep = append(keep, s)
+			continue
+		}
+		if err := extract.Cleanup(s.Path); err != nil {
+			log.Println(err)
+			keep = append(keep, s)
+			continue
+		}
+		if imported {
+			log.Printf("imported: %s\n", filepath.Base(s.Path))
+		}
+	}
+	if len(keep) != len(ss) {
+		keep.save()
+	}
+}
+
+// expected shortest runtime of the searched media
+func runtime(o *Origin) time.Duration {
+	if o == nil {
+		return 0
+	}
+	switch o.Search.Type {
+	case search.TV:
+		return 20 * time.Minute
+	case search.Movie:
+		return 80 * time.Minute
+	}
+	return 0
+}
+
+// copyAll copies the file or the directory tree src to dst
+func copyAll(src string, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, 0755)
+		case !info.Mode().IsRegular():
+			return nil
+		}
+		r, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		w, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY,
+			info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, r)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	})
+}
+
+// move renames src to dst, across filesystems it copies and removes
+func move(src string, dst string) error {
+	err := os.Rename(src, dst)
+	if le, ok := err.(*os.LinkError); !ok || le.Err != syscall.EXDEV {
+		return err
+	}
+	if err = copyAll(src, dst); err != nil {
+		_ = os.RemoveAll(dst)
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// quarantine moves the payload into .quarantine of the download root
+// and erases the download
+func quarantine(d *download.Download) error {
+	path := d.GetDataPath()
+	dir := filepath.Join(d.GetRoot(), ".quarantine")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	dst := filepath.Join(dir, fmt.Sprintf("%s.%s", d.GetHash(),
+		filepath.Base(path)))
+	if err := move(path, dst); err != nil {
+		return err
+	}
+	if !d.Delete() {
+		return fmt.Errorf("command failed, unable to erase %s", d.GetHash())
+	}
+	return nil
+}
+
+// unpack extracts archive sets of the payload into the staging
+// directory, returns paths of the payload and the extracted files.
+// The staging directory is removed once imported, see SweepStaging.
+func (dm *Daemon) unpack(d *download.Download, paths []string) ([]string, error) {
+	if len(extract.Find(paths)) == 0 {
+		return paths, nil
+	}
+	files, err := extract.All(paths, extract.Staging(d.GetDataPath()),
+		dm.Extractor)
+	if err != nil {
+		_ = extract.Cleanup(d.GetDataPath())
+		return paths, err
+	}
+	stage(d.GetDataPath())
+	return append(paths, files...), nil
+}
+
+// onComplete extracts and verifies the completed download, fake
+// releases are quarantined, blocked and replaced by the next best
+// search result. Payloads of other clients than rtorrent are not
+// checked.
+func (dm *Daemon) onComplete(c download.Client, t Tracked) *Media {
+	d, ok := download.GetTorrent(c, t.Hash).(*download.Download)
+	if !ok {
+		log.Printf("%s completed on %s, payload not verified nor extracted\n",
+			t.Name, c.GetSettings().Name)
+		return nil
+	}
+
+	// passworded archives are never extracted
+	paths := d.GetFilePaths()
+	failure := verify.Archives(paths)
+	if failure == nil {
+		paths, failure = dm.unpack(d, paths)
+	}
+	var te *extract.ToolError
+	if errors.As(failure, &te) {
+		// says nothing about the release, it is kept as it is
+		log.Printf("unpack of %s failed: %v\n", t.Name, failure)
+		return nil
+	}
+	if failure == nil {
+		failure = verify.Verify(paths, runtime(t.Origin))
+	}
+	if failure == nil {
+		return nil
+	}
+	_ = extract.Cleanup(d.GetDataPath())
+
+	log.Printf("failed: %s: %v\n", t.Name, failure)
+	if err := quarantine(d); err != nil {
+		// kept in place, not blocked until it is out of the way
+		log.Println(err)
+		return nil
+	}
+	Block(t.Hash, t.Name, failure.Error())
+	return t.Replace(c)
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,177 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/filvarga/tortools/config"
+	"github.com/filvarga/tortools/download"
+	"github.com/filvarga/tortools/search"
+)
+
+// visited returns names of flags given on the command line
+func visited() map[string]bool {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// applyConfig fills in settings from the merged config, flags given on
+// the command line take precedence
+func applyConfig(m *config.Merged, r *download.Rtorrent, reserve *string,
+	tags *arrayTags) {
+
+	set := visited()
+
+	if !set["host"] && len(m.Endpoint.Host) > 0 {
+		r.Host = m.Endpoint.Host
+	}
+	if !set["port"] && m.Endpoint.Port > 0 {
+		r.Port = m.Endpoint.Port
+	}
+	if !set["root"] && len(m.Endpoint.Root) > 0 {
+		r.Root = m.Endpoint.Root
+	}
+	if !set["reserve"] && len(m.Endpoint.Reserve) > 0 {
+		*reserve = m.Endpoint.Reserve
+	}
+	if !set["max-active"] && m.Endpoint.MaxActive > 0 {
+		r.MaxActive = m.Endpoint.MaxActive
+	}
+	if !set["tag"] {
+		for _, tag := range m.Tags {
+			tags.Set(tag)
+		}
+	}
+}
+
+// validateSizes checks sizes of the config file parse, Validate of
+// the config package leaves them out as it doesn't know the units
+func validateSizes(c *config.Config) []error {
+	var errs []error
+
+	for name, e := range c.Endpoints {
+		if len(e.Reserve) > 0 && download.Str2Bytes(e.Reserve, -1) < 0 {
+			errs = append(errs, fmt.Errorf("endpoint %s: invalid reserve %s", name, e.Reserve))
+		}
+	}
+	for name, q := range c.Qualities {
+		if len(q.MaxSize) > 0 && download.Str2Bytes(q.MaxSize, -1) < 0 {
+			errs = append(errs, fmt.Errorf("quality %s: invalid max_size %s", name, q.MaxSize))
+		}
+	}
+	return errs
+}
+
+// selectProviders turns the enabled providers of the merged config
+// into search providers, magnetdl stays the only one when none is
+// configured
+func selectProviders(m *config.Merged) []search.Provider {
+	var (
+		names []string
+		ps    []search.Provider
+	)
+
+	for name := range m.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := m.Providers[name]
+		if p.Disabled {
+			continue
+		}
+		switch p.Type {
+		case "magnetdl":
+			ps = append(ps, search.MagnetDL{})
+		case "torznab":
+			t := &search.Torznab{Title: name, URL: p.URL, APIKey: p.APIKey}
+			for _, c := range strings.Split(p.Settings["categories"], ",") {
+				if id, err := strconv.Atoi(strings.TrimSpace(c)); err == nil {
+					t.Categories = append(t.Categories, id)
+				}
+			}
+			t.PageSize, _ = strconv.Atoi(p.Settings["page_size"])
+			ps = append(ps, t)
+		default:
+			log.Printf("provider %s: unknown type %s\n", name, p.Type)
+		}
+	}
+	if len(ps) == 0 {
+		return []search.Provider{search.MagnetDL{}}
+	}
+	return ps
+}
+
+func showMode(name string, m search.Mode) {
+	if !m.Available {
+		fmt.Printf("  %-13s -\n", name+":")
+		return
+	}
+	fmt.Printf("  %-13s %s\n", name+":", strings.Join(m.Params, ","))
+}
+
+// showCaps prints what the torznab providers are able to search for
+func showCaps(ps []search.Provider) {
+	for _, p := range ps {
+		t, ok := p.(*search.Torznab)
+		if !ok {
+			continue
+		}
+		fmt.Printf("provider: %s\n", t.Name())
+		caps, err := t.Caps()
+		if err != nil {
+			fmt.Printf("  error: %v\n", err)
+			continue
+		}
+		showMode("search", caps.Search)
+		showMode("tv-search", caps.TVSearch)
+		showMode("movie-search", caps.MovieSearch)
+		var cats []string
+		for _, c := range caps.Categories {
+			cats = append(cats, fmt.Sprintf("%d %s", c.ID, c.Name))
+		}
+		fmt.Printf("  %-13s %s\n", "categories:", strings.Join(cats, ", "))
+	}
+}
+
+// selectBackends returns the merged endpoints as backends, or only r
+// when its address is given on the command line, name picks just one
+func selectBackends(m *config.Merged, r download.Rtorrent, name string) Backends {
+	set := visited()
+	if set["host"] || set["port"] {
+		return Backends{&r}
+	}
+	bs := NewBackends(m, r)
+	if len(name) > 0 {
+		b := bs.Get(name)
+		if b == nil {
+			log.Fatalf("backend %s not found", name)
+		}
+		return Backends{b}
+	}
+	return bs
+}
+
+/* vim: set ts=2: */
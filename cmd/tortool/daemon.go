@@ -0,0 +1,89 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"time"
+
+	"github.com/filvarga/tortools/config"
+)
+
+// how often the spool is checked for rtorrent events
+const spoolInterval = 2 * time.Second
+
+type Daemon struct {
+	Interval    time.Duration
+	Stall       time.Duration
+	MetaTimeout time.Duration
+	Extractor   string
+	StagingKeep time.Duration
+	Spool       Spool
+	// release feeds polled for watchlist entries, the quality profile
+	// of an entry is looked up in Qualities, Quality is the default
+	Feeds        map[string]config.Feed
+	FeedInterval time.Duration
+	Qualities    map[string]config.Quality
+	Quality      config.Quality
+}
+
+func (dm *Daemon) poll(bs Backends) {
+	// pending queue, retried as space frees up
+	RetryPending(bs).Show()
+
+	for _, r := range bs {
+		// start queued downloads as slots free up
+		if r.GetSettings().MaxActive > 0 {
+			Balance(r).Show()
+		}
+
+		// replace dead and fake downloads by the next best search result
+		dm.CheckTracked(r).Show()
+	}
+
+	// extracted archives are only needed until imported
+	dm.SweepStaging()
+}
+
+func (dm *Daemon) Run(bs Backends) {
+	poll := time.NewTicker(dm.Interval)
+	defer poll.Stop()
+	spool := time.NewTicker(spoolInterval)
+	defer spool.Stop()
+
+	// no feeds, the feed channel is never ready
+	var feed <-chan time.Time
+	if len(dm.Feeds) > 0 && dm.FeedInterval > 0 {
+		t := time.NewTicker(dm.FeedInterval)
+		defer t.Stop()
+		feed = t.C
+		dm.pollFeeds(bs).Show()
+	}
+
+	dm.poll(bs)
+	for {
+		select {
+		case <-poll.C:
+			dm.poll(bs)
+		case <-feed:
+			dm.pollFeeds(bs).Show()
+		case <-spool.C:
+			// events of rtorrent hooks, see InstallHooks
+			dm.Drain(bs).Show()
+		}
+	}
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,207 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/filvarga/tortools/run"
+)
+
+// docker build context, may be set through -ldflags (see the commented
+// out LDFLAGS of the Makefile), relative ones are looked up from the
+// working directory and the binary
+var buildContext = "docker"
+
+type user struct {
+	idu int
+	idg int
+}
+
+type mount struct {
+	downloads string
+	session   string
+}
+
+type image struct {
+	name string
+	ver  string
+}
+
+func (i *image) String() string {
+	return fmt.Sprintf("%s:%s", i.name, i.ver)
+}
+
+// Deploy drives docker to build and run the rtorrent (app) and nginx
+// (web) containers of docker/Dockerfile
+type Deploy struct {
+	Runner  run.Runner
+	Quiet   bool
+	Context string
+	User    user
+	Mount   mount
+	App     image
+	Web     image
+	AppName string
+	WebName string
+}
+
+func NewDeploy() Deploy {
+	return Deploy{
+		Context: buildContext,
+		User:    user{os.Getuid(), os.Getgid()},
+		Mount:   mount{"/tmp/downloads", "/tmp/session"},
+		App:     image{"app", "latest"},
+		Web:     image{"web", "latest"},
+		AppName: "app",
+		WebName: "web",
+	}
+}
+
+func (dp *Deploy) docker(quiet bool, args ...string) (string, error) {
+	c := run.New("docker", args...)
+	if !quiet {
+		c.Tee(os.Stdout, os.Stderr)
+	}
+	res, err := run.Or(dp.Runner).Run(context.Background(), c)
+	if errors.Is(err, exec.ErrNotFound) {
+		err = fmt.Errorf("docker not found, is it installed and in PATH?")
+	}
+	return strings.TrimSpace(string(res.Stdout)), err
+}
+
+// delContainer removes the container, one already gone is fine
+func (dp *Deploy) delContainer(name string) error {
+	_, err := dp.docker(true, "rm", "-f", name)
+	var ee *run.ExitError
+	if errors.As(err, &ee) && strings.Contains(ee.Tail, "No such container") {
+		return nil
+	}
+	return err
+}
+
+// context resolves a relative build context against the working
+// directory, the directory of the binary and its parent (build/),
+// urls and absolute paths are used as they are
+func (dp *Deploy) context() string {
+	ctx := dp.Context
+	if filepath.IsAbs(ctx) || strings.Contains(ctx, "://") ||
+		strings.HasPrefix(ctx, "git@") {
+		return ctx
+	}
+	dirs := []string{"."}
+	if exe, err := os.Executable(); err == nil {
+		if exe, err = filepath.EvalSymlinks(exe); err == nil {
+			dirs = append(dirs, filepath.Dir(exe), filepath.Dir(filepath.Dir(exe)))
+		}
+	}
+	for _, dir := range dirs {
+		path, err := filepath.Abs(filepath.Join(dir, ctx))
+		if err != nil {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			return path
+		}
+	}
+	return ctx
+}
+
+func (dp *Deploy) build(i image, target string) error {
+	_, err := dp.docker(dp.Quiet, "build",
+		"--build-arg", fmt.Sprintf("IDU=%d", dp.User.idu),
+		"--build-arg", fmt.Sprintf("IDG=%d", dp.User.idg),
+		"--network", "host", "--target", target,
+		"-t", i.String(),
+		dp.context())
+	if err != nil {
+		return fmt.Errorf("building image %s (target %s) failed: %v",
+			i.String(), target, err)
+	}
+	return nil
+}
+
+func (dp *Deploy) deploy(i image, name string) error {
+	_ = dp.delContainer(name)
+	_, err := dp.docker(dp.Quiet, "run",
+		"-v", fmt.Sprintf("%s:/app/downloads", dp.Mount.downloads),
+		"-v", fmt.Sprintf("%s:/app/session", dp.Mount.session),
+		"-d", "--network", "host", "--name", name,
+		i.String())
+	if err != nil {
+		return fmt.Errorf("starting container %s from %s failed: %v",
+			name, i.String(), err)
+	}
+	return nil
+}
+
+// Build builds both images from the docker build context
+func (dp *Deploy) Build() error {
+	if err := dp.build(dp.App, "app"); err != nil {
+		return err
+	}
+	return dp.build(dp.Web, "web")
+}
+
+// Up (re)creates both containers, the mounted directories are created
+// first so they are owned by the user and not by docker
+func (dp *Deploy) Up() error {
+	for _, dir := range []string{dp.Mount.downloads, dp.Mount.session} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("creating mount %s failed: %v", dir, err)
+		}
+	}
+	if err := dp.deploy(dp.App, dp.AppName); err != nil {
+		return err
+	}
+	return dp.deploy(dp.Web, dp.WebName)
+}
+
+// Down removes both containers
+func (dp *Deploy) Down() error {
+	for _, name := range []string{dp.AppName, dp.WebName} {
+		if err := dp.delContainer(name); err != nil {
+			return fmt.Errorf("removing container %s failed: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Status returns state of both containers
+func (dp *Deploy) Status() ([]string, error) {
+	var status []string
+	for _, name := range []string{dp.AppName, dp.WebName} {
+		state, err := dp.docker(true, "inspect",
+			"--format", "{{.State.Status}}", name)
+		if err != nil {
+			state = "missing"
+			var ee *run.ExitError
+			if !errors.As(err, &ee) {
+				return nil, err
+			}
+		}
+		status = append(status, fmt.Sprintf("%s: %s", name, state))
+	}
+	return status, nil
+}
+
+/* vim: set ts=2: */
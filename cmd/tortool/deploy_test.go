@@ -0,0 +1,150 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/filvarga/tortools/run"
+)
+
+func newTestDeploy(t *testing.T) (*Deploy, *run.Fake) {
+	f := &run.Fake{}
+	dp := NewDeploy()
+	dp.Runner = f
+	dp.Quiet = true
+	dir := t.TempDir()
+	dp.Mount = mount{filepath.Join(dir, "downloads"), filepath.Join(dir, "session")}
+	return &dp, f
+}
+
+func commands(f *run.Fake) []string {
+	var out []string
+	for _, argv := range f.Calls() {
+		out = append(out, strings.Join(argv, " "))
+	}
+	return out
+}
+
+func TestDeployBuild(t *testing.T) {
+	dp, f := newTestDeploy(t)
+	dp.Context = t.TempDir()
+	f.On(`^docker build`).Reply("", 0)
+
+	if err := dp.Build(); err != nil {
+		t.Fatal(err)
+	}
+	cmds := commands(f)
+	if len(cmds) != 2 {
+		t.Fatalf("unexpected commands %v", cmds)
+	}
+	for i, target := range []string{"app", "web"} {
+		if !strings.Contains(cmds[i], "--target "+target) ||
+			!strings.HasSuffix(cmds[i], dp.Context) {
+			t.Fatalf("unexpected build %s", cmds[i])
+		}
+	}
+}
+
+func TestDeployBuildFails(t *testing.T) {
+	dp, f := newTestDeploy(t)
+	f.On(`^docker build`).ReplyErr("no space left", 1)
+
+	if err := dp.Build(); err == nil {
+		t.Fatal("failed build succeeded")
+	}
+	if n := len(f.Calls()); n != 1 {
+		t.Fatalf("%d builds run after a failure", n)
+	}
+}
+
+func TestDeployContext(t *testing.T) {
+	dp, _ := newTestDeploy(t)
+
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "docker"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	dp.Context = "docker"
+	if ctx := dp.context(); !filepath.IsAbs(ctx) {
+		t.Fatalf("relative context %s not resolved", ctx)
+	}
+	dp.Context = "https://github.com/filvarga/tortools.git#master:docker"
+	if ctx := dp.context(); ctx != dp.Context {
+		t.Fatalf("url context changed to %s", ctx)
+	}
+}
+
+func TestDeployUp(t *testing.T) {
+	dp, f := newTestDeploy(t)
+	f.On(`^docker rm`).Reply("", 0)
+	f.On(`^docker run`).Reply("id", 0)
+
+	if err := dp.Up(); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{dp.Mount.downloads, dp.Mount.session} {
+		if _, err := os.Stat(dir); err != nil {
+			t.Fatalf("mount %s not created", dir)
+		}
+	}
+	cmds := commands(f)
+	if len(cmds) != 4 || !strings.Contains(cmds[1], "--name app") ||
+		!strings.Contains(cmds[3], "--name web") {
+		t.Fatalf("unexpected commands %v", cmds)
+	}
+}
+
+func TestDeployDown(t *testing.T) {
+	dp, f := newTestDeploy(t)
+	// app is gone already
+	f.On(`^docker rm -f app`).ReplyErr("Error: No such container: app", 1)
+	f.On(`^docker rm -f web`).Reply("web", 0)
+
+	if err := dp.Down(); err != nil {
+		t.Fatal(err)
+	}
+
+	dp, f = newTestDeploy(t)
+	f.On(`^docker rm`).ReplyErr("permission denied", 1)
+	if err := dp.Down(); err == nil {
+		t.Fatal("failed removal succeeded")
+	}
+}
+
+func TestDeployStatus(t *testing.T) {
+	dp, f := newTestDeploy(t)
+	f.On(`inspect .* app$`).Reply("running", 0)
+	f.On(`inspect .* web$`).ReplyErr("Error: No such object: web", 1)
+
+	status, err := dp.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 2 || status[0] != "app: running" || status[1] != "web: missing" {
+		t.Fatalf("unexpected status %v", status)
+	}
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,216 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/filvarga/tortools/config"
+	"github.com/filvarga/tortools/download"
+	"github.com/filvarga/tortools/search"
+	"github.com/filvarga/tortools/store"
+)
+
+// GUIDs and info-hashes remembered, the oldest are forgotten first
+const maxSeen = 5000
+
+// feedState is what the daemon grabbed from feeds: GUIDs and
+// info-hashes of the releases and the keys (episodes, movies) grabbed
+// per watchlist entry
+type feedState struct {
+	Seen    []string
+	Grabbed map[string][]string
+}
+
+func loadFeedState() *feedState {
+	var fs feedState
+	if err := store.Load("feeds", &fs); err != nil {
+		log.Fatal(err)
+	}
+	if fs.Grabbed == nil {
+		fs.Grabbed = make(map[string][]string)
+	}
+	return &fs
+}
+
+func (fs *feedState) save() {
+	if len(fs.Seen) > maxSeen {
+		fs.Seen = fs.Seen[len(fs.Seen)-maxSeen:]
+	}
+	if err := store.Save("feeds", fs); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (fs *feedState) seen(i *search.Item) bool {
+	hash := i.Hash()
+	for _, s := range fs.Seen {
+		if s == i.GUID || (len(hash) > 0 && s == hash) {
+			return true
+		}
+	}
+	return false
+}
+
+func (fs *feedState) grabbed(w *Wanted, key string) bool {
+	for _, k := range fs.Grabbed[strconv.Itoa(w.ID)] {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (fs *feedState) grab(i *search.Item, w *Wanted, key string) {
+	fs.Seen = append(fs.Seen, i.GUID)
+	if hash := i.Hash(); len(hash) > 0 {
+		fs.Seen = append(fs.Seen, hash)
+	}
+	id := strconv.Itoa(w.ID)
+	fs.Grabbed[id] = append(fs.Grabbed[id], key)
+}
+
+// forgetGrabbed drops what was grabbed for the removed entry, its id
+// may be taken by a new one
+func forgetGrabbed(w *Wanted) {
+	fs := loadFeedState()
+	delete(fs.Grabbed, strconv.Itoa(w.ID))
+	fs.save()
+}
+
+// quality returns the quality profile of the entry
+func (dm *Daemon) quality(w *Wanted) config.Quality {
+	if q, ok := dm.Qualities[w.Quality]; ok && len(w.Quality) > 0 {
+		return q
+	}
+	return dm.Quality
+}
+
+// acceptsAny compares the value to accepted ones told the way
+// ParseQuality tells them
+func acceptsAny(value string, accepted []string, pick func(search.Quality) string) bool {
+	for _, a := range accepted {
+		v := pick(search.ParseQuality(a))
+		if len(v) == 0 {
+			v = strings.ToLower(a)
+		}
+		if v == value {
+			return true
+		}
+	}
+	return len(accepted) == 0
+}
+
+// fits reports whether the release fits the quality profile, unknown
+// numbers of seeders (negative) and sizes are not held against it
+func fits(q config.Quality, t search.Torrent) bool {
+	rq := search.ParseQuality(t.Title)
+	if !acceptsAny(rq.Resolution, q.Resolutions, func(p search.Quality) string {
+		return p.Resolution
+	}) || !acceptsAny(rq.Source, q.Sources, func(p search.Quality) string {
+		return p.Source
+	}) || !acceptsAny(rq.Codec, q.Codecs, func(p search.Quality) string {
+		return p.Codec
+	}) {
+		return false
+	}
+	if q.MinSeeders > 0 && t.Seeders >= 0 && t.Seeders < q.MinSeeders {
+		return false
+	}
+	if max := download.Str2Bytes(q.MaxSize, -1); max > 0 {
+		if size := t.GetSize(); size > max {
+			return false
+		}
+	}
+	return true
+}
+
+// pollFeeds grabs releases of the feeds wanted by the watchlist, each
+// release and each episode or movie of an entry is grabbed only once
+func (dm *Daemon) pollFeeds(bs Backends) Medias {
+	var (
+		ms    Medias
+		names []string
+	)
+
+	wl := LoadWatchlist()
+	if len(wl) == 0 {
+		return nil
+	}
+	fs := loadFeedState()
+	bl := LoadBlocklist()
+
+	for name := range dm.Feeds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := dm.Feeds[name]
+		if f.Disabled {
+			continue
+		}
+		items, err := search.FetchItems(f.URL, name)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		for i := range items {
+			item := &items[i]
+			if fs.seen(item) || !bl.accepts(item.Torrent) {
+				continue
+			}
+			r := search.ParseRelease(item.Torrent.Title)
+			for j := range wl {
+				w := &wl[j]
+				if w.Paused {
+					continue
+				}
+				key, ok := w.match(r, item.Torrent.Title)
+				if !ok || fs.grabbed(w, key) {
+					continue
+				}
+				if !fits(dm.quality(w), item.Torrent) {
+					continue
+				}
+				// .torrent links are told apart by their info-hash
+				if len(item.Hash()) == 0 {
+					if err := item.Resolve(); err != nil {
+						log.Printf("feed %s: %s skipped, %v\n", name,
+							item.Torrent.Title, err)
+						break
+					}
+					if fs.seen(item) || !bl.accepts(item.Torrent) {
+						break
+					}
+				}
+				m := convertTorrent(item.Torrent)
+				m.origin = &Origin{Search: w.search(r), Tags: w.Tags}
+				if m.Get(bs.Route(m)) || m.Pending {
+					fs.grab(item, w, key)
+					ms = append(ms, *m)
+				}
+				break
+			}
+		}
+	}
+	fs.save()
+	return ms
+}
+
+/* vim: set ts=2: */
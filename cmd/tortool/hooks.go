@@ -0,0 +1,142 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/filvarga/tortools/download"
+)
+
+// rtorrent events tortools reacts to
+var events = []string{"finished", "erased", "hash_failed"}
+
+// Spool is the directory rtorrent notifies tortools through, an empty
+// <hash>.<event> file is created for every event. Local is the path
+// seen by tortools, Remote the same directory seen by rtorrent.
+type Spool struct {
+	Local  string
+	Remote string
+}
+
+func isEvent(event string) bool {
+	for _, e := range events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// InstallHooks sets rtorrent event handlers, with an empty tortool
+// path rtorrent creates the spool files itself, otherwise it executes
+// "tortool hook <event> <hash>" which must run on the rtorrent host,
+// both write into the spool as rtorrent sees it (Remote)
+func (sp *Spool) InstallHooks(r download.Rtorrent, tortool string) error {
+	if len(tortool) == 0 {
+		if err := r.Execute("mkdir", "-p", sp.Remote); err != nil {
+			return err
+		}
+	}
+	for _, event := range events {
+		var command string
+		if len(tortool) > 0 {
+			command = fmt.Sprintf("execute.nothrow.bg=%s,-spool,%s,hook,%s,(d.hash)",
+				tortool, sp.Remote, event)
+		} else {
+			command = fmt.Sprintf("execute.nothrow.bg=touch,(cat,%s/,(d.hash),.%s)",
+				strings.TrimSuffix(sp.Remote, "/"), event)
+		}
+		if err := r.SetEventHandler(event, "tortools_"+event, command); err != nil {
+			return fmt.Errorf("installing %s hook failed: %v", event, err)
+		}
+	}
+	return nil
+}
+
+// Notify is the hook subcommand, it records the event in the spool
+func (sp *Spool) Notify(event string, hash string) error {
+	if !isEvent(event) || len(hash) == 0 {
+		return fmt.Errorf("invalid event %s %s", event, hash)
+	}
+	if err := os.MkdirAll(sp.Local, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(sp.Local, fmt.Sprintf("%s.%s", strings.ToUpper(hash), event))
+	return ioutil.WriteFile(path, nil, 0644)
+}
+
+// Drain reacts to and removes every event in the spool
+func (dm *Daemon) Drain(bs Backends) Medias {
+	var ms Medias
+
+	files, err := ioutil.ReadDir(dm.Spool.Local)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	for _, f := range files {
+		parts := strings.SplitN(f.Name(), ".", 2)
+		if len(parts) == 2 && isEvent(parts[1]) {
+			if m := dm.onEvent(bs, parts[1], parts[0]); m != nil {
+				ms = append(ms, *m)
+			}
+		}
+		if err = os.Remove(filepath.Join(dm.Spool.Local, f.Name())); err != nil {
+			log.Println(err)
+		}
+	}
+	return ms
+}
+
+func (dm *Daemon) onEvent(bs Backends, event string, hash string) *Media {
+	t := untrack(hash)
+	if t == nil {
+		return nil
+	}
+	r := bs.Get(t.Backend)
+	if r == nil {
+		return nil
+	}
+
+	switch event {
+	case "finished":
+		if r.GetSettings().MaxActive > 0 {
+			Balance(r).Show()
+		}
+		return dm.onComplete(r, *t)
+	case "hash_failed":
+		log.Printf("hash failed: %s\n", t.Name)
+		Block(t.Hash, t.Name, "hash failed")
+		if d := download.GetTorrent(r, t.Hash); d != nil {
+			convertDownload(d).DelWithData()
+		}
+		return t.Replace(r)
+	case "erased":
+		// nothing to do but to stop tracking
+	}
+	return nil
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,112 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/filvarga/tortools/metadata"
+	"github.com/filvarga/tortools/verify"
+)
+
+// metadataTimeout bounds resolving the metadata of a magnet before it
+// is added, zero skips the inspection
+var metadataTimeout time.Duration
+
+// rankCandidates is the number of search results resolved by rank
+const rankCandidates = 5
+
+// size is the size of the remote media, the real one once resolved
+func (m *Media) size() int64 {
+	if m.info != nil {
+		return m.info.Size
+	}
+	return m.torrent.GetSize()
+}
+
+// inspect resolves the metadata of the remote media, returns its size
+// (-1 when unknown) and whether it looks like a real release. Fake
+// releases are blocked right away.
+func (m *Media) inspect() (int64, bool) {
+	if metadataTimeout <= 0 || m.inspected {
+		return m.size(), true
+	}
+	m.inspected = true
+
+	info, err := metadata.Fetch(m.torrent.Magnet, metadataTimeout)
+	if err != nil {
+		// metadata isn't always available, rtorrent may do better
+		log.Println(err)
+		return m.size(), true
+	}
+	if err = verify.Names(info.Paths()); err != nil {
+		log.Printf("fake: %s: %v\n", m.Name, err)
+		Block(info.Hash, m.Name, err.Error())
+		return m.size(), false
+	}
+	m.info = info
+	return m.size(), true
+}
+
+// rank resolves the metadata of the first rankCandidates remote medias
+// at once, fakes are dropped and the real ones go first ordered by
+// size, the largest first. Medias without metadata keep their order.
+func rank(ms Medias) Medias {
+	var (
+		wg      sync.WaitGroup
+		genuine = make([]bool, len(ms))
+		ranked  Medias
+		rest    Medias
+	)
+
+	if metadataTimeout <= 0 {
+		return ms
+	}
+	for i := range ms {
+		if i == rankCandidates {
+			break
+		}
+		if ms[i].Local || ms[i].torrent == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, genuine[i] = ms[i].inspect()
+		}(i)
+	}
+	wg.Wait()
+
+	for i, m := range ms {
+		switch {
+		case m.info != nil:
+			ranked = append(ranked, m)
+		case i < rankCandidates && !m.Local && m.torrent != nil && !genuine[i]:
+			// fake
+		default:
+			rest = append(rest, m)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].info.Size > ranked[j].info.Size
+	})
+	return append(ranked, rest...)
+}
+
+/* vim: set ts=2: */
@@ -18,101 +18,53 @@ package main
 import (
 	"flag"
 	"fmt"
+	"github.com/filvarga/tortools/config"
 	"github.com/filvarga/tortools/download"
+	"github.com/filvarga/tortools/httpx"
 	"github.com/filvarga/tortools/search"
+	"log"
 	"os"
 	"strings"
+	"time"
 )
 
-/* TODO: infrastructure installation and configuration
-var (
-	context = ""
-)
-
-type user struct {
-	idu int
-	idg int
-}
-
-type mount struct {
-	downloads string
-	session   string
-}
-
-type image struct {
-	name string
-	ver  string
-}
-
-func delContainer(name string) error {
-	return run.Exec3(true, "docker", "rm", "-f", name)
-}
-
-func (i *image) build(quiet bool, target string, u user) error {
-	return run.Exec3(quiet, "docker", "build",
-		"--build-arg", fmt.Sprintf("IDU:%d", u.idu),
-		"--build-arg", fmt.Sprintf("IDG:%d", u.idg),
-		"--network", "host", "--target", target,
-		"-t", fmt.Sprintf("%s:%s", i.name, i.ver),
-		context)
-}
-
-func (i *image) deploy(quiet bool, name string, m mount) error {
-	_ = delContainer(name)
-	return run.Exec3(quiet, "docker", "run", "-it",
-		"-v", fmt.Sprintf("%s:/app/downloads", m.downloads),
-		"-v", fmt.Sprintf("%s:/app/session", m.session),
-		"-d", "--network", "host", "--name", name,
-		fmt.Sprintf("%s:%s", i.name, i.ver))
-}
-
-func build() {
-	var err error
-
-	u := user{1000, 1000}
-	app := image{"app", "latest"}
-	web := image{"web", "latest"}
-
-	err = app.build(false, "app", u)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	err = web.build(false, "web", u)
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
-func deploy() {
-	var err error
-
-	m := mount{"/tmp/downloads", "/tmp/session"}
-	app := image{"app", "latest"}
-	web := image{"web", "latest"}
-
-	err = app.deploy(false, "app", m)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	err = web.deploy(false, "web", m)
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-*/
-
 func printUsage() {
 	usage := `Usage:
-%[1]s	download purge
-%[1]s	download list
-%[1]s [-tag <tag> ...] download del <title> [season] [episode]
-%[1]s	[-tag <tag> ...] search find|get <title> [season] [episode]
+%[1]s	[-config <file>] [-profile <name>] config show|validate
+%[1]s	[-with-data] download purge
+%[1]s	[-state <view>] [-sort name|size|progress|ratio|added] download list
+%[1]s	[-refresh <duration>] [-tag <tag> ...] download watch [title] [season] [episode]
+%[1]s	download view list
+%[1]s	download view add <name> [filter]
+%[1]s [-tag <tag> ...] [-with-data] download del <title> [season] [episode]
+%[1]s	[-tag <tag> ...] [-limit <n>] search find|get <title> [season] [episode]
+%[1]s	search caps
 %[1]s [-tag <tag> ...] find all|first <title> [season] [episode]
-%[1]s	[-tag <tag> ...] get all|first <title> [season] [episode]
-%[1]s	[-tag <tag> ...] del all|first <title> [season] [episode]
-%[1]s run
+%[1]s	[-tag <tag> ...] [-metadata <duration>] get all|first <title> [season] [episode]
+%[1]s	[-tag <tag> ...] [-with-data] del all|first <title> [season] [episode]
+%[1]s	[-tag <tag> ...] -to <backend> download move <title> [season] [episode]
+%[1]s	[-tag <tag> ...] ui
+%[1]s	pending list|retry
+%[1]s	[-reason <reason>] block add hash|pattern|group <value>
+%[1]s	block list
+%[1]s	block rm <position>
+%[1]s	[-tag <tag> ...] [-quality <name>] watch add series <title> [season [episode]]
+%[1]s	[-tag <tag> ...] [-quality <name>] watch add movie <title> [year]
+%[1]s	[-tag <tag> ...] [-quality <name>] watch add terms <terms>
+%[1]s	watch list
+%[1]s	watch rm|pause|resume <position>
+%[1]s	queue list|balance
+%[1]s	queue move <from> <to>
+%[1]s	[-uid <uid>] [-gid <gid>] [-image-tag <tag>] deploy build
+%[1]s	[-downloads <dir>] [-session <dir>] deploy up|down|status
+%[1]s	[-spool <dir>] [-rtorrent-spool <dir>] hook install [tortool]
+%[1]s	[-spool <dir>] hook finished|erased|hash_failed <hash>
+%[1]s [-interval <duration>] [-stall <duration>] [-feed-interval <duration>] run
+%[1]s	[-listen <addr>] [-api-key <key>] serve
+
+Watched series are grabbed from the season and episode given on, a
+season alone means from its first episode on. Adding a watched title
+again updates it.
 
 Flags:
 `
@@ -133,10 +85,14 @@ func (i *arrayTags) Set(value string) error {
 	return nil
 }
 
+// searchLimit caps the number of search results, zero is unlimited
+var searchLimit int
+
 func buildSearch() search.Search {
 	s := search.Search{
 		Season:  1,
 		Episode: 1,
+		Limit:   searchLimit,
 	}
 	s.Title = flag.Arg(2)
 	if len(s.Title) == 0 {
@@ -155,19 +111,114 @@ func buildSearch() search.Search {
 	return s
 }
 
+func del(ms Medias, withData bool) {
+	if withData {
+		freed := ms.DelWithData()
+		fmt.Printf("freed: %s\n", download.Bytes2Str(freed))
+	} else {
+		ms.Del()
+	}
+}
+
 func main() {
 
 	var (
-		tags arrayTags
-		r    download.Rtorrent
+		tags     arrayTags
+		r        download.Rtorrent
+		withData bool
+		reserve  string
+		reason   string
+		quality  string
+		state    string
+		sortKey  string
+		refresh  time.Duration
+		dm       Daemon
+		sv       Server
+		dp       = NewDeploy()
+		cfgPath  string
+		profile  string
+		cfg      *config.Merged
+		backend  string
+		moveTo   string
+		bs       Backends
 	)
 
+	flag.StringVar(&cfgPath, "config", config.Path(), "Config file")
+	flag.StringVar(&profile, "profile", "", "Config profile")
+	flag.StringVar(&backend, "backend", "", "Use only the named backend")
+	flag.StringVar(&moveTo, "to", "", "Backend to move downloads to")
 	flag.StringVar(&r.Host, "host", "localhost", "Provider host")
 	flag.IntVar(&r.Port, "port", 80, "Provider port")
 	flag.Var(&tags, "tag", "Contains tag")
+	flag.BoolVar(&withData, "with-data", false, "Delete downloaded data as well")
+	flag.StringVar(&r.Root, "root", "/app/downloads", "Download root directory")
+	flag.StringVar(&reserve, "reserve", "1G", "Disk space kept free in download root")
+	flag.StringVar(&state, "state", "", "Download state (rtorrent view)")
+	flag.StringVar(&sortKey, "sort", "name", "Sort downloads by")
+	flag.DurationVar(&refresh, "refresh", 2*time.Second, "Watch refresh interval")
+	flag.StringVar(&reason, "reason", "manual", "Reason for blocking a release")
+	flag.StringVar(&quality, "quality", "", "Quality profile of a watchlist entry, empty is the one of the config profile")
+	flag.IntVar(&r.MaxActive, "max-active", 0, "Maximum active downloads, 0 is unlimited")
+	flag.IntVar(&dp.User.idu, "uid", dp.User.idu, "Container user id")
+	flag.IntVar(&dp.User.idg, "gid", dp.User.idg, "Container group id")
+	flag.StringVar(&dp.Mount.downloads, "downloads", dp.Mount.downloads, "Host downloads directory")
+	flag.StringVar(&dp.Mount.session, "session", dp.Mount.session, "Host session directory")
+	flag.StringVar(&dp.Context, "context", dp.Context, "Docker build context")
+	flag.StringVar(&dp.App.ver, "image-tag", dp.App.ver, "Image tag")
+	flag.StringVar(&dp.AppName, "app-name", dp.AppName, "Rtorrent container name")
+	flag.StringVar(&dp.WebName, "web-name", dp.WebName, "Nginx container name")
+	flag.StringVar(&dm.Spool.Local, "spool", "/tmp/session/spool", "Spool directory of rtorrent events")
+	flag.StringVar(&dm.Spool.Remote, "rtorrent-spool", "/app/session/spool", "Spool directory seen by rtorrent")
+	flag.StringVar(&sv.Addr, "listen", "127.0.0.1:9118", "Address the torznab api listens on")
+	flag.StringVar(&sv.APIKey, "api-key", "", "Key torznab api clients have to pass, required unless listening on loopback")
+	flag.DurationVar(&dm.Interval, "interval", time.Minute, "Daemon polling interval")
+	flag.DurationVar(&dm.Stall, "stall", 6*time.Hour, "Replace downloads without progress for")
+	flag.DurationVar(&dm.MetaTimeout, "meta-timeout", time.Hour, "Replace magnets without metadata after")
+	flag.DurationVar(&dm.FeedInterval, "feed-interval", 15*time.Minute, "Release feed polling interval")
+	flag.DurationVar(&dm.StagingKeep, "staging-keep", 7*24*time.Hour, "Remove extracted archives not imported after, 0 waits for the import")
+	flag.StringVar(&dm.Extractor, "extractor", "", "Archive extractor command with {src} and {dst}")
+	flag.DurationVar(&metadataTimeout, "metadata", 0, "Resolve magnet metadata to check and rank results for, 0 skips it")
+	flag.IntVar(&searchLimit, "limit", 0, "Maximum search results, 0 is unlimited")
+	flag.DurationVar(&search.HTTP.Interval, "delay", httpx.DefaultInterval, "Delay between requests to a search provider")
+	flag.DurationVar(&search.HTTP.TTL, "cache", httpx.DefaultTTL, "Search result cache lifetime, 0 disables it")
 
 	flag.Parse()
 
+	c, err := config.Load(cfgPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if flag.Arg(0) == "config" && flag.Arg(1) == "validate" {
+		// check config file before it gets merged
+		errs := append(c.Validate(), validateSizes(c)...)
+		for _, err := range errs {
+			fmt.Println(err)
+		}
+		if len(errs) > 0 {
+			os.Exit(1)
+		}
+	}
+	if cfg, err = c.Merge(profile); err != nil {
+		log.Fatal(err)
+	}
+	applyConfig(cfg, &r, &reserve, &tags)
+	search.Providers = selectProviders(cfg)
+	dm.Feeds, dm.Qualities, dm.Quality = c.Feeds, c.Qualities, cfg.Quality
+
+	r.Reserve = download.Str2Bytes(reserve, -1)
+	if r.Reserve < 0 {
+		printUsage()
+	}
+	bs = selectBackends(cfg, r, backend)
+
+	// the state is a view every backend has to have
+	for _, b := range bs {
+		if !download.HasView(b, state) {
+			log.Fatalf("invalid state %s of backend %s", state,
+				b.GetSettings().Name)
+		}
+	}
+
 	switch flag.Arg(0) {
 	default:
 		printUsage()
@@ -177,19 +228,73 @@ func main() {
 			printUsage()
 		case "purge":
 			// purge all downloads
-			m := ListAllDownloads(r)
+			m := bs.ListDownloads("")
 			m.Show()
-			m.Del()
+			del(m, withData)
 		case "list":
 			// list all downloads
-			m := ListAllDownloads(r)
+			if err := checkSortKey(sortKey); err != nil {
+				log.Fatal(err)
+			}
+			m := bs.ListDownloads(state)
+			if err := m.Sort(sortKey); err != nil {
+				log.Fatal(err)
+			}
 			m.Show()
+		case "watch":
+			// watch progress until all matching downloads complete
+			var watched Medias
+			if len(flag.Arg(2)) > 0 {
+				watched = bs.FindDownloads(buildSearch(), tags)
+			} else {
+				for _, m := range bs.ListDownloads(state) {
+					if contains(m.Name, tags) {
+						watched = append(watched, m)
+					}
+				}
+			}
+			os.Exit(Watch(bs, watched, refresh))
+		case "view":
+			switch flag.Arg(2) {
+			default:
+				printUsage()
+			case "list":
+				// list built in and custom views
+				for _, v := range r.GetViews() {
+					if download.IsBuiltinView(v) {
+						fmt.Println(v)
+					} else {
+						fmt.Printf("%s (custom)\n", v)
+					}
+				}
+			case "add":
+				// add custom view, optionally filtered
+				if len(flag.Arg(3)) == 0 {
+					printUsage()
+				}
+				if err := r.AddView(flag.Arg(3), flag.Arg(4)); err != nil {
+					log.Fatal(err)
+				}
+			}
+		case "move":
+			// hand matching downloads over to another backend
+			to := bs.Get(moveTo)
+			if to == nil {
+				log.Fatalf("backend %s not found", moveTo)
+			}
+			for _, m := range bs.FindDownloads(buildSearch(), tags) {
+				moved, err := bs.Move(&m, to)
+				if err != nil {
+					log.Fatal(err)
+				}
+				moved.Show()
+			}
 		case "del":
 			// del all downloads matching search pattern
-			m := FindFirstDownloadB(r, buildSearch(), tags)
+			m := bs.FindFirstDownload(buildSearch(), tags)
 			if m != nil {
 				m.Show()
-				m.Del()
+				del(Medias{*m}, withData)
 			}
 		}
 	case "search":
@@ -199,12 +304,15 @@ func main() {
 		case "find":
 			// find all searches matching search pattern
 			m := FindTorrentsB(buildSearch(), tags)
-			m.Show()
+			m.ShowDetails()
 		case "get":
 			// get all searches matching search pattern
 			m := FindTorrentsB(buildSearch(), tags)
-			m.Get(r)
+			m.Get(bs)
 			m.Show()
+		case "caps":
+			// capabilities of the torznab providers
+			showCaps(search.Providers)
 		}
 	case "find":
 		switch flag.Arg(1) {
@@ -213,14 +321,14 @@ func main() {
 		case "first":
 			// find first managed media matching search pattern
 			// TODO: managed media layer
-			m := FindFirstB(r, buildSearch(), tags)
+			m := bs.FindFirst(buildSearch(), tags)
 			if m != nil {
 				m.Show()
 			}
 		case "all":
 			// find all managed media matching search pattern
 			// TODO: managed media layer
-			m := FindAllB(r, buildSearch(), tags)
+			m := bs.FindAll(buildSearch(), tags)
 			m.Show()
 		}
 	case "get":
@@ -230,16 +338,16 @@ func main() {
 		case "first":
 			// get first managed media matching search pattern
 			// TODO: managed media layer
-			m := FindFirstB(r, buildSearch(), tags)
+			m := bs.FindFirst(buildSearch(), tags)
 			if m != nil {
-				m.Get(r)
+				m.Get(bs.Route(m))
 				m.Show()
 			}
 		case "all":
 			// get all managed media matching search pattern
 			// TODO: managed media layer
-			m := FindAllB(r, buildSearch(), tags)
-			m.Get(r)
+			m := bs.FindAll(buildSearch(), tags)
+			m.Get(bs)
 			m.Show()
 		}
 	case "del":
@@ -249,20 +357,234 @@ func main() {
 		case "first":
 			// del first managed media matching search pattern
 			// TODO: managed media layer
-			m := FindFirstDownloadB(r, buildSearch(), tags)
+			m := bs.FindFirstDownload(buildSearch(), tags)
 			if m != nil {
 				m.Show()
-				m.Del()
+				del(Medias{*m}, withData)
 			}
 		case "all":
 			// del all managed media matching search pattern
 			// TODO: managed media layer
-			m := FindDownloadsB(r, buildSearch(), tags)
+			m := bs.FindDownloads(buildSearch(), tags)
+			m.Show()
+			del(m, withData)
+		}
+	case "config":
+		switch flag.Arg(1) {
+		default:
+			printUsage()
+		case "show":
+			// print merged config
+			fmt.Println(cfg.String())
+		case "validate":
+			// validated above already
+			fmt.Printf("%s: ok\n", cfgPath)
+		}
+	case "ui":
+		// interactive search and download manager
+		if err := UI(bs, tags); err != nil {
+			log.Fatal(err)
+		}
+	case "pending":
+		switch flag.Arg(1) {
+		default:
+			printUsage()
+		case "list":
+			// list torrents deferred for lack of disk space
+			m := ListPending()
 			m.Show()
-			m.Del()
+		case "retry":
+			// add deferred torrents that fit into free space
+			m := RetryPending(bs)
+			m.Show()
+		}
+	case "queue":
+		switch flag.Arg(1) {
+		default:
+			printUsage()
+		case "list":
+			// list queued downloads by priority
+			q := LoadQueue()
+			q.Show()
+		case "balance":
+			// start queued downloads as slots free up
+			for _, b := range bs {
+				m := Balance(b)
+				m.Show()
+			}
+		case "move":
+			// change priority of a queued download
+			from := download.Str2Int(flag.Arg(2), -1)
+			to := download.Str2Int(flag.Arg(3), -1)
+			q, err := LoadQueue().Move(from, to)
+			if err != nil {
+				log.Fatal(err)
+			}
+			q.Save()
+			q.Show()
+		}
+	case "block":
+		switch flag.Arg(1) {
+		default:
+			printUsage()
+		case "add":
+			// block releases by info-hash, name pattern or group
+			b := Blocked{Reason: reason}
+			value := flag.Arg(3)
+			if len(value) == 0 {
+				printUsage()
+			}
+			switch flag.Arg(2) {
+			default:
+				printUsage()
+			case "hash":
+				if strings.HasPrefix(value, "magnet:") {
+					b.Hash = download.MagnetHash(value)
+				} else {
+					b.Hash = download.ParseHash(value)
+				}
+				if len(b.Hash) == 0 {
+					log.Fatalf("invalid info-hash %s", value)
+				}
+			case "pattern":
+				b.Pattern = value
+			case "group":
+				b.Group = value
+			}
+			bl, err := LoadBlocklist().Add(b)
+			if err != nil {
+				log.Fatal(err)
+			}
+			bl.Save()
+			bl.Show()
+		case "list":
+			// list blocked releases
+			bl := LoadBlocklist()
+			bl.Show()
+		case "rm":
+			// unblock release at position
+			bl, err := LoadBlocklist().Remove(download.Str2Int(flag.Arg(2), -1))
+			if err != nil {
+				log.Fatal(err)
+			}
+			bl.Save()
+			bl.Show()
+		}
+	case "watch":
+		switch flag.Arg(1) {
+		default:
+			printUsage()
+		case "add":
+			// grabbed from release feeds by the daemon
+			w := Wanted{Type: flag.Arg(2), Title: flag.Arg(3), Tags: tags}
+			if len(w.Title) == 0 {
+				printUsage()
+			}
+			if _, ok := c.Qualities[quality]; len(quality) > 0 && !ok {
+				log.Fatalf("quality %s not found", quality)
+			}
+			w.Quality = quality
+			switch w.Type {
+			case WantSeries:
+				if len(flag.Arg(4)) > 0 {
+					w.Season = download.Str2Int(flag.Arg(4), -1)
+				}
+				if len(flag.Arg(5)) > 0 {
+					w.Episode = download.Str2Int(flag.Arg(5), -1)
+				}
+				if w.Season < 0 || w.Episode < 0 {
+					printUsage()
+				}
+			case WantMovie:
+				if len(flag.Arg(4)) > 0 {
+					w.Year = download.Str2Int(flag.Arg(4), -1)
+					if w.Year < 0 {
+						printUsage()
+					}
+				}
+			case WantTerms:
+				w.Title = strings.Join(flag.Args()[3:], " ")
+			}
+			wl, updated, err := LoadWatchlist().Add(w)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if updated {
+				fmt.Printf("already watched, updated: %s\n", w.Title)
+			}
+			wl.Save()
+			wl.Show()
+		case "list":
+			// list watched series, movies and terms
+			LoadWatchlist().Show()
+		case "rm":
+			// stop watching entry at position
+			wl, w, err := LoadWatchlist().Remove(download.Str2Int(flag.Arg(2), -1))
+			if err != nil {
+				log.Fatal(err)
+			}
+			wl.Save()
+			forgetGrabbed(w)
+			wl.Show()
+		case "pause", "resume":
+			// keep entry at position but grab nothing for it
+			wl, err := LoadWatchlist().Pause(download.Str2Int(flag.Arg(2), -1),
+				flag.Arg(1) == "pause")
+			if err != nil {
+				log.Fatal(err)
+			}
+			wl.Save()
+			wl.Show()
+		}
+	case "deploy":
+		dp.Web.ver = dp.App.ver
+		switch flag.Arg(1) {
+		default:
+			printUsage()
+		case "build":
+			// build rtorrent and nginx images
+			if err := dp.Build(); err != nil {
+				log.Fatal(err)
+			}
+		case "up":
+			// (re)create rtorrent and nginx containers
+			if err := dp.Up(); err != nil {
+				log.Fatal(err)
+			}
+		case "down":
+			// remove rtorrent and nginx containers
+			if err := dp.Down(); err != nil {
+				log.Fatal(err)
+			}
+		case "status":
+			// show state of rtorrent and nginx containers
+			status, err := dp.Status()
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(strings.Join(status, "\n"))
+		}
+	case "hook":
+		switch flag.Arg(1) {
+		default:
+			// called by rtorrent on download event
+			if err := dm.Spool.Notify(flag.Arg(1), flag.Arg(2)); err != nil {
+				log.Fatal(err)
+			}
+		case "":
+			printUsage()
+		case "install":
+			// install rtorrent event handlers
+			if err := dm.Spool.InstallHooks(r, flag.Arg(2)); err != nil {
+				log.Fatal(err)
+			}
 		}
 	case "run":
 		// TODO: manage library
+		dm.Run(bs)
+	case "serve":
+		// torznab indexer of the search providers
+		log.Fatal(sv.Serve())
 	}
 }
 
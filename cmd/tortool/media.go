@@ -17,27 +17,41 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/filvarga/tortools/download"
+	"github.com/filvarga/tortools/extract"
+	"github.com/filvarga/tortools/metadata"
 	"github.com/filvarga/tortools/search"
 )
 
 type Media struct {
 	Name     string
 	Local    bool
+	Pending  bool
+	Backend  string
 	Type     int
 	Season   int
 	Episode  int
 	torrent  *search.Torrent
-	download *download.Download
+	download download.Torrent
+	origin   *Origin
+	// metadata resolved by inspect
+	info      *metadata.Info
+	inspected bool
 }
 
 type Medias []Media
 
 func (m *Media) String() string {
-	if m.Local {
+	if m.Pending {
+		return fmt.Sprintf("pending: %s", m.Name)
+	} else if m.Local && len(m.Backend) > 0 {
+		return fmt.Sprintf("local:  %s [%s]", m.Name, m.Backend)
+	} else if m.Local {
 		return fmt.Sprintf("local:  %s", m.Name)
 	} else {
 		return fmt.Sprintf("remote: %s", m.Name)
@@ -48,20 +62,46 @@ func (m *Media) Show() {
 	fmt.Println(m.String())
 }
 
-func (m *Media) Get(r download.Rtorrent) bool {
+func (m *Media) Get(c download.Client) bool {
 	if !m.Local {
-		d := r.AddDownload(m.torrent.Magnet)
+		size, ok := m.inspect()
+		if !ok {
+			return false
+		}
+		if !download.HasSpace(c, size) {
+			// not enough space, retried later from pending queue
+			deferTorrent(*m.torrent, size, m.origin)
+			m.Pending = true
+			return false
+		}
+		d := addTorrent(c, m.torrent.Magnet)
 		if d == nil {
 			return false
 		}
+		Track(d, m.origin)
 		// local has precedence over remote
 		m.Name = d.GetName()
 		m.Local = true
+		m.Backend = d.GetBackend()
 		m.download = d
 	}
 	return true
 }
 
+// addTorrent starts the download right away unless the number of
+// active downloads is limited, then it is queued
+func addTorrent(c download.Client, magnet string) download.Torrent {
+	if c.GetSettings().MaxActive <= 0 {
+		return download.AddTorrent(c, magnet, true)
+	}
+	d := download.AddTorrent(c, magnet, false)
+	if d != nil {
+		Enqueue(d)
+		Balance(c)
+	}
+	return d
+}
+
 func (m *Media) Del() bool {
 	if m.Local {
 		return m.download.Delete()
@@ -69,15 +109,68 @@ func (m *Media) Del() bool {
 	return false
 }
 
+// DelWithData deletes the download together with its payload, returns
+// number of bytes freed. Other clients than rtorrent remove payloads
+// themselves.
+func (m *Media) DelWithData() int64 {
+	if m.Local {
+		d, ok := m.download.(*download.Download)
+		if !ok {
+			freed, err := m.download.(*download.Handle).DeleteWithData()
+			if err != nil {
+				log.Println(err)
+			}
+			return freed
+		}
+		// staging directory of extracted archives goes as well
+		if err := extract.Cleanup(d.GetDataPath()); err != nil {
+			log.Println(err)
+		}
+		freed, err := d.DeleteWithData(d.GetRoot())
+		if err != nil {
+			log.Println(err)
+		}
+		return freed
+	}
+	return 0
+}
+
 func (ms Medias) Show() {
 	for _, m := range ms {
 		m.Show()
 	}
 }
 
-func (ms Medias) Get(r download.Rtorrent) {
+// ShowDetails shows remote medias along with what their provider
+// tells about them: size, seeders, leechers, upload date and category
+func (ms Medias) ShowDetails() {
 	for _, m := range ms {
-		m.Get(r)
+		if m.Local || m.torrent == nil {
+			m.Show()
+			continue
+		}
+		t := m.torrent
+		size := "-"
+		if n := t.GetSize(); n >= 0 {
+			size = download.Bytes2Str(n)
+		}
+		uploaded := "-"
+		if !t.Uploaded.IsZero() {
+			uploaded = t.Uploaded.Format("2006-01-02")
+		}
+		category := "-"
+		if len(t.Category) > 0 {
+			category = t.Category
+		}
+		fmt.Printf("remote: %-10s %5d %5d %-10s %-8s %-8s %s\n", size,
+			t.Seeders, t.Leechers, uploaded, category, t.Provider, m.Name)
+	}
+}
+
+// Get routes every media to a backend
+func (ms Medias) Get(bs Backends) {
+	for i := range ms {
+		ms[i].Get(bs.Route(&ms[i]))
 	}
 }
 
@@ -87,6 +180,14 @@ func (ms Medias) Del() {
 	}
 }
 
+func (ms Medias) DelWithData() int64 {
+	var freed int64
+	for _, m := range ms {
+		freed += m.DelWithData()
+	}
+	return freed
+}
+
 func convertTorrent(t search.Torrent) *Media {
 	return &Media{
 		Name:    t.Title,
@@ -95,11 +196,12 @@ func convertTorrent(t search.Torrent) *Media {
 	}
 }
 
-func convertDownload(d download.Download) *Media {
+func convertDownload(d download.Torrent) *Media {
 	return &Media{
 		Name:     d.GetName(),
 		Local:    true,
-		download: &d,
+		Backend:  d.GetBackend(),
+		download: d,
 	}
 }
 
@@ -111,7 +213,7 @@ func convertTorrents(ts search.Torrents) Medias {
 	return ms
 }
 
-func convertDownloads(ds download.Downloads) Medias {
+func convertDownloads(ds []download.Torrent) Medias {
 	var ms Medias
 	for _, d := range ds {
 		ms = append(ms, *convertDownload(d))
@@ -119,47 +221,147 @@ func convertDownloads(ds download.Downloads) Medias {
 	return ms
 }
 
-func findTorrents(s search.Search) search.Torrents {
-	return s.GetTorrents()
+// findTorrents returns results not blocked and having all tags, the
+// others don't count against the limit of the search
+func findTorrents(s search.Search, tags []string) search.Torrents {
+	blocklist := LoadBlocklist()
+	ts, err := s.Find(func(t search.Torrent) bool {
+		return contains(t.Title, tags) && blocklist.accepts(t)
+	})
+	if err != nil {
+		log.Println(err)
+	}
+	return ts
+}
+
+// firstOnly limits the search to the results the first match is picked
+// from, ranking them by metadata needs a few
+func firstOnly(s search.Search) search.Search {
+	limit := 1
+	if metadataTimeout > 0 {
+		limit = rankCandidates
+	}
+	if s.Limit <= 0 || s.Limit > limit {
+		s.Limit = limit
+	}
+	return s
+}
+
+// originate remembers the search remote medias were found by
+func originate(ms Medias, s search.Search, tags []string) Medias {
+	for i := range ms {
+		if !ms[i].Local {
+			ms[i].origin = &Origin{Search: s, Tags: tags}
+		}
+	}
+	return ms
 }
 
-func findDownloads(r download.Rtorrent, s search.Search) download.Downloads {
-	ds := r.GetDownloads()
+func findDownloads(c download.Client, s search.Search) []download.Torrent {
+	var (
+		re  *regexp.Regexp
+		out []download.Torrent
+	)
+
 	if s.Type == search.TV {
-		return ds.FindAll(regexp.MustCompile(
-			fmt.Sprintf(`(?i)%s.*?s%02de%02d`,
-				s.Title, s.Season, s.Episode)))
+		re = regexp.MustCompile(fmt.Sprintf(`(?i)%s.*?s%02de%02d`,
+			s.Title, s.Season, s.Episode))
 	} else {
-		return ds.FindAll(regexp.MustCompile(
-			fmt.Sprintf(`(?i)%s`, s.Title)))
+		re = regexp.MustCompile(fmt.Sprintf(`(?i)%s`, s.Title))
 	}
+	for _, d := range download.GetTorrents(c, "") {
+		if re.MatchString(d.GetName()) {
+			out = append(out, d)
+		}
+	}
+	return out
 }
 
-func ListAllDownloads(r download.Rtorrent) Medias {
-	return convertDownloads(r.GetDownloads())
+func ListAllDownloads(c download.Client) Medias {
+	return convertDownloads(download.GetTorrents(c, ""))
 }
 
-func FindTorrentsA(s search.Search) Medias {
-	return convertTorrents(findTorrents(s))
+// ListDownloads lists downloads of the view (state), views of other
+// clients than rtorrent are emulated
+func ListDownloads(c download.Client, view string) Medias {
+	return convertDownloads(download.GetTorrents(c, view))
 }
 
-func FindTorrentsB(s search.Search, tags []string) Medias {
-	var ms Medias
-	for _, m := range FindTorrentsA(s) {
-		if contains(m.Name, tags) {
-			ms = append(ms, m)
+// keys medias are sorted by
+var sortKeys = []string{"name", "size", "progress", "ratio", "added"}
+
+func checkSortKey(key string) error {
+	for _, k := range sortKeys {
+		if k == key {
+			return nil
 		}
 	}
-	return ms
+	return fmt.Errorf("invalid sort key %s", key)
 }
 
-func FindDownloadsA(r download.Rtorrent, s search.Search) Medias {
-	return convertDownloads(findDownloads(r, s))
+// Sort orders medias ascending by name, size, progress, ratio or
+// added (date), remote medias only have a name
+func (ms Medias) Sort(key string) error {
+	var keys []float64
+
+	if err := checkSortKey(key); err != nil {
+		return err
+	}
+	if key == "name" {
+		sort.SliceStable(ms, func(i, j int) bool {
+			return strings.ToLower(ms[i].Name) < strings.ToLower(ms[j].Name)
+		})
+		return nil
+	}
+
+	for _, m := range ms {
+		var value float64
+		if m.Local {
+			switch key {
+			case "size":
+				value = float64(m.download.GetBytesSize())
+			case "progress":
+				value = m.download.GetPercentDone()
+			case "ratio":
+				value = m.download.GetRatio()
+			case "added":
+				value = float64(m.download.GetLoadDate())
+			}
+		}
+		keys = append(keys, value)
+	}
+
+	// keys travel along with medias while sorting
+	idx := make([]int, len(ms))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return keys[idx[i]] < keys[idx[j]]
+	})
+	sorted := make(Medias, len(ms))
+	for i, k := range idx {
+		sorted[i] = ms[k]
+	}
+	copy(ms, sorted)
+	return nil
+}
+
+func FindTorrentsA(s search.Search) Medias {
+	return convertTorrents(findTorrents(s, nil))
+}
+
+func FindTorrentsB(s search.Search, tags []string) Medias {
+	return originate(convertTorrents(findTorrents(s, tags)), s, tags)
+}
+
+func FindDownloadsA(c download.Client, s search.Search) Medias {
+	return convertDownloads(findDownloads(c, s))
 }
 
-func FindDownloadsB(r download.Rtorrent, s search.Search, tags []string) Medias {
+func FindDownloadsB(c download.Client, s search.Search, tags []string) Medias {
 	var ms Medias
-	for _, m := range FindDownloadsA(r, s) {
+	for _, m := range FindDownloadsA(c, s) {
 		if contains(m.Name, tags) {
 			ms = append(ms, m)
 		}
@@ -167,74 +369,77 @@ func FindDownloadsB(r download.Rtorrent, s search.Search, tags []string) Medias
 	return ms
 }
 
-func FindFirstDownloadA(r download.Rtorrent, s search.Search) *Media {
-	downloads := findDownloads(r, s)
+func FindFirstDownloadA(c download.Client, s search.Search) *Media {
+	downloads := findDownloads(c, s)
 	if len(downloads) > 0 {
 		return convertDownload(downloads[0])
 	}
 	return nil
 }
 
-func FindFirstDownloadB(r download.Rtorrent, s search.Search, tags []string) *Media {
+func FindFirstDownloadB(c download.Client, s search.Search, tags []string) *Media {
 	if len(tags) > 0 {
-		for _, m := range convertDownloads(findDownloads(r, s)) {
+		for _, m := range convertDownloads(findDownloads(c, s)) {
 			if contains(m.Name, tags) {
 				return &m
 			}
 		}
 	} else {
-		return FindFirstDownloadA(r, s)
+		return FindFirstDownloadA(c, s)
 	}
 	return nil
 }
 
-func FindAllA(r download.Rtorrent, s search.Search) Medias {
+func FindAllA(c download.Client, s search.Search) Medias {
 	var ms Medias
-	for _, t := range convertDownloads(findDownloads(r, s)) {
+	for _, t := range convertDownloads(findDownloads(c, s)) {
 		ms = append(ms, t)
 	}
-	for _, t := range convertTorrents(findTorrents(s)) {
+	for _, t := range convertTorrents(findTorrents(s, nil)) {
 		ms = append(ms, t)
 	}
 	return ms
 }
 
-func FindAllB(r download.Rtorrent, s search.Search, tags []string) Medias {
+func FindAllB(c download.Client, s search.Search, tags []string) Medias {
 	var ms Medias
-	for _, m := range FindAllA(r, s) {
+	for _, m := range FindAllA(c, s) {
 		if contains(m.Name, tags) {
 			ms = append(ms, m)
 		}
 	}
-	return ms
+	return originate(ms, s, tags)
 }
 
-func FindFirstA(r download.Rtorrent, s search.Search) *Media {
-	downloads := findDownloads(r, s)
+func FindFirstA(c download.Client, s search.Search) *Media {
+	downloads := findDownloads(c, s)
 	if len(downloads) > 0 {
 		return convertDownload(downloads[0])
 	}
-	torrents := findTorrents(s)
+	torrents := findTorrents(firstOnly(s), nil)
 	if len(torrents) > 0 {
 		return convertTorrent(torrents[0])
 	}
 	return nil
 }
 
-func FindFirstB(r download.Rtorrent, s search.Search, tags []string) *Media {
+func FindFirstB(c download.Client, s search.Search, tags []string) *Media {
 	if len(tags) > 0 {
-		for _, m := range convertDownloads(findDownloads(r, s)) {
+		for _, m := range convertDownloads(findDownloads(c, s)) {
 			if contains(m.Name, tags) {
 				return &m
 			}
 		}
-		for _, m := range convertTorrents(findTorrents(s)) {
-			if contains(m.Name, tags) {
-				return &m
-			}
+		if ts := findTorrents(firstOnly(s), tags); len(ts) > 0 {
+			m := convertTorrent(ts[0])
+			m.origin = &Origin{Search: s, Tags: tags}
+			return m
 		}
-	} else {
-		return FindFirstA(r, s)
+	} else if m := FindFirstA(c, s); m != nil {
+		if !m.Local {
+			m.origin = &Origin{Search: s, Tags: tags}
+		}
+		return m
 	}
 	return nil
 }
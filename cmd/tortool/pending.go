@@ -0,0 +1,103 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/filvarga/tortools/download"
+	"github.com/filvarga/tortools/search"
+	"github.com/filvarga/tortools/store"
+)
+
+// Pending is a torrent deferred for lack of disk space
+type Pending struct {
+	Torrent search.Torrent
+	Size    int64
+	Origin  *Origin
+	Added   time.Time
+}
+
+type Pendings []Pending
+
+func loadPending() Pendings {
+	var ps Pendings
+	if err := store.Load("pending", &ps); err != nil {
+		log.Fatal(err)
+	}
+	return ps
+}
+
+func (ps Pendings) save() {
+	if err := store.Save("pending", ps); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func deferTorrent(t search.Torrent, size int64, o *Origin) {
+	ps := loadPending()
+	for _, p := range ps {
+		if p.Torrent.Magnet == t.Magnet {
+			return
+		}
+	}
+	ps = append(ps, Pending{Torrent: t, Size: size, Origin: o, Added: time.Now()})
+	ps.save()
+}
+
+func ListPending() Medias {
+	var ms Medias
+	for _, p := range loadPending() {
+		m := convertTorrent(p.Torrent)
+		m.Pending = true
+		ms = append(ms, *m)
+	}
+	return ms
+}
+
+// RetryPending adds pending torrents in the order they were deferred
+// for as long as there is enough space for them
+func RetryPending(bs Backends) Medias {
+	var (
+		ms   Medias
+		keep Pendings
+	)
+
+	ps := loadPending()
+	for i, p := range ps {
+		m := convertTorrent(p.Torrent)
+		m.origin = p.Origin
+		r := bs.Route(m)
+		if !download.HasSpace(r, p.Size) {
+			keep = append(keep, ps[i:]...)
+			break
+		}
+		d := addTorrent(r, p.Torrent.Magnet)
+		if d == nil {
+			keep = append(keep, p)
+			continue
+		}
+		Track(d, p.Origin)
+		ms = append(ms, *convertDownload(d))
+	}
+	if len(keep) != len(ps) {
+		keep.save()
+	}
+	return ms
+}
+
+/* vim: set ts=2: */
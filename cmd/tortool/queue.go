@@ -0,0 +1,174 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/filvarga/tortools/download"
+	"github.com/filvarga/tortools/store"
+)
+
+// Queued is a download waiting for a free slot, position in the queue
+// is its priority
+type Queued struct {
+	Hash    string
+	Name    string
+	Backend string
+}
+
+type Queue []Queued
+
+func LoadQueue() Queue {
+	var q Queue
+	if err := store.Load("queue", &q); err != nil {
+		log.Fatal(err)
+	}
+	return q
+}
+
+func (q Queue) Save() {
+	if err := store.Save("queue", q); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (q Queue) Show() {
+	for i, e := range q {
+		if len(e.Backend) > 0 {
+			fmt.Printf("%3d queued: %s [%s]\n", i+1, e.Name, e.Backend)
+		} else {
+			fmt.Printf("%3d queued: %s\n", i+1, e.Name)
+		}
+	}
+}
+
+func Enqueue(d download.Torrent) {
+	q := LoadQueue()
+	q = append(q, Queued{
+		Hash:    d.GetHash(),
+		Name:    d.GetName(),
+		Backend: d.GetBackend(),
+	})
+	q.Save()
+}
+
+// requeue hands the queue entry of a download moved to c over to it,
+// the entry keeps its priority. Downloads c doesn't limit leave the
+// queue, limited ones not queued yet are appended.
+func requeue(d download.Torrent, c download.Client) {
+	var (
+		keep   Queue
+		queued bool
+	)
+
+	limited := c.GetSettings().MaxActive > 0
+	for _, e := range LoadQueue() {
+		if e.Hash == d.GetHash() {
+			queued = true
+			if !limited {
+				continue
+			}
+			e.Backend = d.GetBackend()
+		}
+		keep = append(keep, e)
+	}
+	keep.Save()
+
+	if limited {
+		if !queued {
+			Enqueue(d)
+		}
+		Balance(c)
+	}
+}
+
+// Move changes priority of the download at position from (1 based)
+func (q Queue) Move(from int, to int) (Queue, error) {
+	var out Queue
+
+	if from < 1 || from > len(q) || to < 1 || to > len(q) {
+		return q, fmt.Errorf("invalid queue position")
+	}
+	for i, e := range q {
+		if i != from-1 {
+			out = append(out, e)
+		}
+	}
+	out = append(out[:to-1], append(Queue{q[from-1]}, out[to-1:]...)...)
+	return out, nil
+}
+
+func (q Queue) contains(hash string) bool {
+	for _, e := range q {
+		if e.Hash == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// Balance starts queued downloads by priority as long as the number
+// of leeching downloads stays under MaxActive of the client, queued
+// downloads over the limit are stopped, completed ones leave the queue.
+// Clients without MaxActive are unlimited, all their queued downloads
+// are started.
+func Balance(c download.Client) Medias {
+	var (
+		ms   Medias
+		keep Queue
+	)
+
+	q := LoadQueue()
+	ds := download.GetTorrents(c, "")
+
+	// leeching downloads not managed by the queue take slots first
+	slots := c.GetSettings().MaxActive
+	unlimited := slots <= 0
+	byHash := make(map[string]download.Torrent)
+	for _, d := range ds {
+		byHash[d.GetHash()] = d
+		if !q.contains(d.GetHash()) && d.IsStarted() && !d.IsComplete() {
+			slots--
+		}
+	}
+
+	for _, e := range q {
+		if e.Backend != c.GetSettings().Name {
+			keep = append(keep, e)
+			continue
+		}
+		d, ok := byHash[e.Hash]
+		if !ok || d.IsComplete() {
+			continue
+		}
+		keep = append(keep, e)
+		if unlimited || slots > 0 {
+			slots--
+			if !d.IsStarted() {
+				d.Start()
+				ms = append(ms, *convertDownload(d))
+			}
+		} else if d.IsStarted() {
+			d.Stop()
+		}
+	}
+	keep.Save()
+	return ms
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,422 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/filvarga/tortools/download"
+	"github.com/filvarga/tortools/search"
+)
+
+const (
+	torznabNS = "http://torznab.com/schemas/2015/feed"
+
+	// results a single request returns at most
+	serveMax = 100
+
+	// searching the providers takes a while, the answer may take longer
+	// than reading the request
+	serveReadTimeout  = 30 * time.Second
+	serveWriteTimeout = 5 * time.Minute
+	serveIdleTimeout  = 2 * time.Minute
+)
+
+// torznab error codes
+const (
+	errCredentials = 100
+	errParameter   = 200
+	errFunction    = 202
+	errUnknown     = 900
+)
+
+// Server is a Torznab indexer backed by the search providers, so any
+// Torznab consumer can use them
+type Server struct {
+	Addr   string
+	APIKey string
+}
+
+type xmlError struct {
+	XMLName     xml.Name `xml:"error"`
+	Code        int      `xml:"code,attr"`
+	Description string   `xml:"description,attr"`
+}
+
+type xmlMode struct {
+	Available string `xml:"available,attr"`
+	Params    string `xml:"supportedParams,attr"`
+}
+
+type xmlCategory struct {
+	ID   int           `xml:"id,attr"`
+	Name string        `xml:"name,attr"`
+	Sub  []xmlCategory `xml:"subcat"`
+}
+
+type xmlCaps struct {
+	XMLName xml.Name `xml:"caps"`
+	Server  struct {
+		Title string `xml:"title,attr"`
+	} `xml:"server"`
+	Limits struct {
+		Max     int `xml:"max,attr"`
+		Default int `xml:"default,attr"`
+	} `xml:"limits"`
+	Search      xmlMode       `xml:"searching>search"`
+	TVSearch    xmlMode       `xml:"searching>tv-search"`
+	MovieSearch xmlMode       `xml:"searching>movie-search"`
+	Categories  []xmlCategory `xml:"categories>category"`
+}
+
+type xmlAttr struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type xmlItem struct {
+	Title     string `xml:"title"`
+	GUID      string `xml:"guid"`
+	Link      string `xml:"link"`
+	Comments  string `xml:"comments,omitempty"`
+	PubDate   string `xml:"pubDate,omitempty"`
+	Size      int64  `xml:"size,omitempty"`
+	Enclosure struct {
+		URL    string `xml:"url,attr"`
+		Length int64  `xml:"length,attr"`
+		Type   string `xml:"type,attr"`
+	} `xml:"enclosure"`
+	Attrs []xmlAttr `xml:"torznab:attr"`
+}
+
+type xmlRSS struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	NS      string   `xml:"xmlns:torznab,attr"`
+	Channel struct {
+		Title    string `xml:"title"`
+		Response struct {
+			Offset int `xml:"offset,attr"`
+			Total  int `xml:"total,attr"`
+		} `xml:"torznab:response"`
+		Items []xmlItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+var categories = []xmlCategory{
+	{ID: 2000, Name: "Movies", Sub: []xmlCategory{
+		{ID: 2030, Name: "Movies/SD"},
+		{ID: 2040, Name: "Movies/HD"},
+		{ID: 2045, Name: "Movies/UHD"},
+	}},
+	{ID: 5000, Name: "TV", Sub: []xmlCategory{
+		{ID: 5030, Name: "TV/SD"},
+		{ID: 5040, Name: "TV/HD"},
+		{ID: 5045, Name: "TV/UHD"},
+	}},
+	{ID: 8000, Name: "Other"},
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	b, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	w.Write(b)
+}
+
+func writeError(w http.ResponseWriter, code int, description string) {
+	writeXML(w, xmlError{Code: code, Description: description})
+}
+
+func writeCaps(w http.ResponseWriter) {
+	var c xmlCaps
+	c.Server.Title = "tortools"
+	c.Limits.Max = serveMax
+	c.Limits.Default = serveMax
+	c.Search = xmlMode{Available: "yes", Params: "q"}
+	c.TVSearch = xmlMode{Available: "yes", Params: "q,season,ep"}
+	c.MovieSearch = xmlMode{Available: "no", Params: "q"}
+	c.Categories = categories
+	writeXML(w, c)
+}
+
+// categoryID picks the newznab category of the result, the release
+// parser tells the resolution
+func categoryID(t *search.Torrent, tv bool) int {
+	base := 8000
+	switch {
+	case tv || t.Category == "tv":
+		base = 5000
+	case t.Category == "movie":
+		base = 2000
+	default:
+		return base
+	}
+	switch search.ParseQuality(t.Title).Resolution {
+	case "":
+		return base
+	case "2160p":
+		return base + 45
+	case "1080p", "720p":
+		return base + 40
+	}
+	return base + 30
+}
+
+// wanted reports whether the category is one of the requested ones,
+// parent categories include their subcategories
+func wanted(id int, cats []int) bool {
+	if len(cats) == 0 {
+		return true
+	}
+	for _, c := range cats {
+		if c == id || (c%1000 == 0 && id/1000 == c/1000) {
+			return true
+		}
+	}
+	return false
+}
+
+func item(t *search.Torrent, cat int) xmlItem {
+	var x xmlItem
+
+	hash := download.MagnetHash(t.Magnet)
+	x.Title = t.Title
+	x.GUID = t.Magnet
+	if len(hash) > 0 {
+		x.GUID = hash
+	}
+	x.Link = t.Magnet
+	x.Comments = t.Link
+	if !t.Uploaded.IsZero() {
+		x.PubDate = t.Uploaded.Format(time.RFC1123Z)
+	}
+	size := t.GetSize()
+	if size > 0 {
+		x.Size = size
+	}
+	x.Enclosure.URL = t.Magnet
+	x.Enclosure.Length = x.Size
+	x.Enclosure.Type = "application/x-bittorrent"
+
+	attr := func(name string, value string) {
+		x.Attrs = append(x.Attrs, xmlAttr{Name: name, Value: value})
+	}
+	attr("category", strconv.Itoa(cat))
+	if size > 0 {
+		attr("size", strconv.FormatInt(size, 10))
+	}
+	attr("seeders", strconv.Itoa(t.Seeders))
+	attr("peers", strconv.Itoa(t.Seeders+t.Leechers))
+	if len(hash) > 0 {
+		attr("infohash", strings.ToLower(hash))
+	}
+	if strings.HasPrefix(t.Magnet, "magnet:") {
+		attr("magneturl", t.Magnet)
+	}
+	return x
+}
+
+func atoi(s string, def int) int {
+	if i, err := strconv.Atoi(s); err == nil && i >= 0 {
+		return i
+	}
+	return def
+}
+
+// buildQuery turns torznab parameters into a search, season without an
+// episode searches the whole season by title
+func buildQuery(r *http.Request) (search.Search, bool) {
+	q := r.URL.Query()
+
+	s := parseQuery(q.Get("q"))
+	if q.Get("t") != "tvsearch" {
+		return s, s.Type == search.TV
+	}
+	season := atoi(strings.TrimPrefix(strings.ToUpper(q.Get("season")), "S"), -1)
+	episode := atoi(strings.TrimPrefix(strings.ToUpper(q.Get("ep")), "E"), -1)
+	switch {
+	case season >= 0 && episode >= 0:
+		s.Type = search.TV
+		s.Season = season
+		s.Episode = episode
+	case season >= 0:
+		s.Title = fmt.Sprintf("%s s%02d", s.Title, season)
+	}
+	return s, true
+}
+
+func (sv *Server) search(w http.ResponseWriter, r *http.Request) {
+	var (
+		cats []int
+		rss  xmlRSS
+	)
+
+	q := r.URL.Query()
+	s, tv := buildQuery(r)
+	for _, c := range strings.Split(q.Get("cat"), ",") {
+		if id, err := strconv.Atoi(strings.TrimSpace(c)); err == nil {
+			cats = append(cats, id)
+		}
+	}
+	offset := atoi(q.Get("offset"), 0)
+	limit := atoi(q.Get("limit"), serveMax)
+	if limit == 0 || limit > serveMax {
+		limit = serveMax
+	}
+
+	// an empty query asks for the latest releases, the providers have
+	// none without a title to search for. A result past the page tells
+	// consumers there is a next one, total is never less than what is
+	// known to exist.
+	var ts search.Torrents
+	if len(s.Title) > 0 {
+		var err error
+		blocklist := LoadBlocklist()
+		s.Limit = offset + limit + 1
+		ts, err = s.Find(func(t search.Torrent) bool {
+			return blocklist.accepts(t) && wanted(categoryID(&t, tv), cats)
+		})
+		if err != nil && len(ts) == 0 {
+			writeError(w, errUnknown, err.Error())
+			return
+		} else if err != nil {
+			log.Println(err)
+		}
+	}
+
+	rss.Version = "2.0"
+	rss.NS = torznabNS
+	rss.Channel.Title = "tortools"
+	total := len(ts)
+	if offset > total {
+		offset = total
+	}
+	ts = ts[offset:]
+	if len(ts) > limit {
+		ts = ts[:limit]
+	}
+	for i := range ts {
+		rss.Channel.Items = append(rss.Channel.Items,
+			item(&ts[i], categoryID(&ts[i], tv)))
+	}
+	rss.Channel.Response.Offset = offset
+	rss.Channel.Response.Total = total
+	writeXML(w, rss)
+}
+
+func (sv *Server) api(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if len(sv.APIKey) > 0 && q.Get("apikey") != sv.APIKey {
+		writeError(w, errCredentials, "Incorrect user credentials")
+		return
+	}
+	switch q.Get("t") {
+	case "":
+		writeError(w, errParameter, "Missing parameter (t)")
+	case "caps":
+		writeCaps(w)
+	case "search", "tvsearch":
+		sv.search(w, r)
+	default:
+		writeError(w, errFunction, "No such function")
+	}
+}
+
+// queue lists the download queue (GET) and reorders it (POST with
+// from and to positions), answers are the queue in JSON
+func (sv *Server) queue(w http.ResponseWriter, r *http.Request) {
+	if len(sv.APIKey) > 0 && r.URL.Query().Get("apikey") != sv.APIKey {
+		http.Error(w, "Incorrect user credentials", http.StatusUnauthorized)
+		return
+	}
+	q := LoadQueue()
+	switch r.Method {
+	case "GET":
+	case "POST":
+		from := atoi(r.FormValue("from"), -1)
+		to := atoi(r.FormValue("to"), -1)
+		moved, err := q.Move(from, to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		moved.Save()
+		q = moved
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if q == nil {
+		q = Queue{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(q)
+}
+
+// loopback reports whether addr is reachable from this host only
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (sv *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api", sv.api)
+	mux.HandleFunc("/api/", sv.api)
+	mux.HandleFunc("/queue", sv.queue)
+	return mux
+}
+
+// Serve answers Torznab requests on /api and queue requests on /queue
+// until it fails. Addresses other than loopback ones need an API key.
+func (sv *Server) Serve() error {
+	if len(sv.APIKey) == 0 && !loopback(sv.Addr) {
+		return fmt.Errorf("serving on %s needs an api key", sv.Addr)
+	}
+
+	srv := &http.Server{
+		Addr:              sv.Addr,
+		Handler:           sv.handler(),
+		ReadHeaderTimeout: serveReadTimeout,
+		ReadTimeout:       serveReadTimeout,
+		WriteTimeout:      serveWriteTimeout,
+		IdleTimeout:       serveIdleTimeout,
+	}
+	log.Printf("serving torznab on %s/api\n", sv.Addr)
+	return srv.ListenAndServe()
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,264 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/filvarga/tortools/search"
+)
+
+// provider answers every search by the same results in one page, the
+// searches asked are recorded
+type provider struct {
+	ts       search.Torrents
+	searches []search.Search
+}
+
+func (p *provider) Name() string {
+	return "test"
+}
+
+func (p *provider) Page(s *search.Search, page int) ([]search.Torrent, bool, error) {
+	p.searches = append(p.searches, *s)
+	return p.ts, true, nil
+}
+
+func result(title string, category string, seeders int) search.Torrent {
+	return search.Torrent{
+		Title:    title,
+		Category: category,
+		Seeders:  seeders,
+		Size:     -1,
+		Magnet:   fmt.Sprintf("magnet:?xt=urn:btih:%040d", seeders),
+	}
+}
+
+// testServer serves results of a test provider, state files go to a
+// temporary directory
+func testServer(t *testing.T, key string) (*httptest.Server, *provider) {
+	p := &provider{ts: search.Torrents{
+		result("Show.S01E01.1080p.WEB", "tv", 60),
+		result("Show.S01E01.720p.HDTV", "tv", 50),
+		result("Show.S01E01.2160p.WEB", "tv", 40),
+		result("Show.S01E01.480p.HDTV", "tv", 30),
+		result("Movie.2020.1080p.BluRay", "movie", 20),
+		result("Some.Ebook.EPUB", "", 10),
+	}}
+
+	state := os.Getenv("TORTOOLS_STATE")
+	providers := search.Providers
+	os.Setenv("TORTOOLS_STATE", t.TempDir())
+	search.Providers = []search.Provider{p}
+
+	s := httptest.NewServer((&Server{APIKey: key}).handler())
+	t.Cleanup(func() {
+		s.Close()
+		search.Providers = providers
+		os.Setenv("TORTOOLS_STATE", state)
+	})
+	return s, p
+}
+
+type testRSS struct {
+	Channel struct {
+		Response struct {
+			Offset int `xml:"offset,attr"`
+			Total  int `xml:"total,attr"`
+		} `xml:"response"`
+		Items []struct {
+			Title string    `xml:"title"`
+			Attrs []xmlAttr `xml:"attr"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+func get(t *testing.T, s *httptest.Server, query string, v interface{}) {
+	resp, err := http.Get(s.URL + "/api?" + query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = xml.Unmarshal(b, v); err != nil {
+		t.Fatalf("%s: %v", b, err)
+	}
+}
+
+func titles(rss testRSS) []string {
+	var out []string
+	for _, i := range rss.Channel.Items {
+		out = append(out, i.Title)
+	}
+	return out
+}
+
+func TestServeCaps(t *testing.T) {
+	s, _ := testServer(t, "")
+
+	var caps xmlCaps
+	get(t, s, "t=caps", &caps)
+	if caps.Server.Title != "tortools" || caps.Limits.Max != serveMax ||
+		caps.TVSearch.Available != "yes" || caps.TVSearch.Params != "q,season,ep" ||
+		caps.MovieSearch.Available != "no" {
+		t.Fatalf("caps %+v", caps)
+	}
+	if len(caps.Categories) != 3 || caps.Categories[1].ID != 5000 ||
+		caps.Categories[1].Sub[1].ID != 5040 {
+		t.Fatalf("categories %+v", caps.Categories)
+	}
+
+	var e xmlError
+	get(t, s, "", &e)
+	if e.Code != errParameter {
+		t.Fatalf("error %+v", e)
+	}
+	get(t, s, "t=music", &e)
+	if e.Code != errFunction {
+		t.Fatalf("error %+v", e)
+	}
+}
+
+func TestServeAPIKey(t *testing.T) {
+	s, p := testServer(t, "key")
+
+	for _, query := range []string{"t=caps", "t=caps&apikey=wrong", "t=search&q=Show"} {
+		var e xmlError
+		get(t, s, query, &e)
+		if e.Code != errCredentials {
+			t.Fatalf("%s: error %+v", query, e)
+		}
+	}
+	if len(p.searches) != 0 {
+		t.Fatal("searched without the api key")
+	}
+
+	var caps xmlCaps
+	get(t, s, "t=caps&apikey=key", &caps)
+	if caps.Server.Title != "tortools" {
+		t.Fatalf("caps %+v", caps)
+	}
+
+	resp, err := http.Get(s.URL + "/queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("queue status %d without the api key", resp.StatusCode)
+	}
+}
+
+func TestBuildQuery(t *testing.T) {
+	for query, want := range map[string]struct {
+		s  search.Search
+		tv bool
+	}{
+		"t=tvsearch&q=Show&season=1&ep=2": {search.Search{Type: search.TV,
+			Title: "Show", Season: 1, Episode: 2}, true},
+		"t=tvsearch&q=Show&season=S03&ep=E04": {search.Search{Type: search.TV,
+			Title: "Show", Season: 3, Episode: 4}, true},
+		"t=tvsearch&q=Show&season=2": {search.Search{Title: "Show s02"}, true},
+		"t=tvsearch&q=Show&ep=2":     {search.Search{Title: "Show"}, true},
+		"t=search&q=Show+S01E02": {search.Search{Type: search.TV,
+			Title: "Show", Season: 1, Episode: 2}, true},
+		"t=search&q=Movie+2020": {search.Search{Title: "Movie 2020"}, false},
+		// season and ep only apply to tv searches
+		"t=search&q=Movie&season=1&ep=2": {search.Search{Title: "Movie"}, false},
+	} {
+		r := httptest.NewRequest("GET", "/api?"+query, nil)
+		s, tv := buildQuery(r)
+		if s != want.s || tv != want.tv {
+			t.Fatalf("%s: search %+v tv %v, expected %+v %v", query, s, tv, want.s, want.tv)
+		}
+	}
+}
+
+func TestServeCategories(t *testing.T) {
+	s, _ := testServer(t, "")
+
+	for cat, want := range map[string]int{
+		"":          6,
+		"5040":      2,
+		"5000":      4,
+		"2000,5045": 2,
+		"8000":      1,
+		"7000":      0,
+	} {
+		var rss testRSS
+		get(t, s, "t=search&q=Show&cat="+url.QueryEscape(cat), &rss)
+		if len(rss.Channel.Items) != want || rss.Channel.Response.Total != want {
+			t.Fatalf("cat %s: %v total %d, expected %d", cat, titles(rss),
+				rss.Channel.Response.Total, want)
+		}
+	}
+
+	// results of a tv search are tv whatever the provider tells
+	var rss testRSS
+	get(t, s, "t=tvsearch&q=Show&season=1&ep=1&cat=5000", &rss)
+	if len(rss.Channel.Items) != 6 {
+		t.Fatalf("tv search %v", titles(rss))
+	}
+}
+
+func TestServePaging(t *testing.T) {
+	s, p := testServer(t, "")
+
+	for _, c := range []struct {
+		offset, limit int
+		first         string
+		items, total  int
+		// results asked from the providers
+		asked int
+	}{
+		{0, 2, "Show.S01E01.1080p.WEB", 2, 3, 3},
+		{2, 2, "Show.S01E01.2160p.WEB", 2, 5, 5},
+		{4, 2, "Movie.2020.1080p.BluRay", 2, 6, 7},
+		{5, 2, "Some.Ebook.EPUB", 1, 6, 8},
+		{10, 2, "", 0, 6, 13},
+	} {
+		var rss testRSS
+		get(t, s, fmt.Sprintf("t=search&q=Show&offset=%d&limit=%d", c.offset, c.limit), &rss)
+		ts := titles(rss)
+		if len(ts) != c.items || rss.Channel.Response.Total != c.total ||
+			(len(ts) > 0 && ts[0] != c.first) {
+			t.Fatalf("offset %d limit %d: %v total %d", c.offset, c.limit, ts,
+				rss.Channel.Response.Total)
+		}
+		if asked := p.searches[len(p.searches)-1].Limit; asked != c.asked {
+			t.Fatalf("offset %d limit %d: asked for %d results", c.offset, c.limit, asked)
+		}
+	}
+
+	// no limit or one over the maximum gives the maximum
+	var rss testRSS
+	get(t, s, "t=search&q=Show&limit=1000", &rss)
+	if p.searches[len(p.searches)-1].Limit != serveMax+1 {
+		t.Fatalf("asked for %d results", p.searches[len(p.searches)-1].Limit)
+	}
+}
+
+/* vim: set ts=2: */
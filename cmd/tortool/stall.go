@@ -0,0 +1,205 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/filvarga/tortools/download"
+	"github.com/filvarga/tortools/search"
+	"github.com/filvarga/tortools/store"
+)
+
+// Origin is the search a download was grabbed from, used to find a
+// replacement when the download turns out dead
+type Origin struct {
+	Search search.Search
+	Tags   []string
+}
+
+// Tracked is a download whose progress is watched by the daemon
+type Tracked struct {
+	Hash     string
+	Backend  string
+	Name     string
+	Origin   *Origin
+	Added    time.Time
+	Bytes    int
+	Progress time.Time
+	// last seen stopped (queued) or moved, metadata is waited for since
+	Started time.Time
+}
+
+type Trackeds []Tracked
+
+func loadTracked() Trackeds {
+	var ts Trackeds
+	if err := store.Load("tracked", &ts); err != nil {
+		log.Fatal(err)
+	}
+	return ts
+}
+
+func (ts Trackeds) save() {
+	if err := store.Save("tracked", ts); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func Track(d download.Torrent, o *Origin) {
+	now := time.Now()
+	ts := loadTracked()
+	ts = append(ts, Tracked{
+		Hash:     d.GetHash(),
+		Backend:  d.GetBackend(),
+		Name:     d.GetName(),
+		Origin:   o,
+		Added:    now,
+		Progress: now,
+		Started:  now,
+	})
+	ts.save()
+}
+
+// retrack notes the download moved to another backend, where it
+// starts over
+func retrack(d download.Torrent) {
+	now := time.Now()
+	ts := loadTracked()
+	for i := range ts {
+		if ts[i].Hash == d.GetHash() {
+			ts[i].Backend = d.GetBackend()
+			ts[i].Progress = now
+			ts[i].Started = now
+		}
+	}
+	ts.save()
+}
+
+// untrack stops tracking the download, returns what was tracked
+func untrack(hash string) *Tracked {
+	var (
+		found *Tracked
+		keep  Trackeds
+	)
+
+	ts := loadTracked()
+	for i, t := range ts {
+		if t.Hash == hash {
+			found = &ts[i]
+		} else {
+			keep = append(keep, t)
+		}
+	}
+	if found != nil {
+		keep.save()
+	}
+	return found
+}
+
+// stalled reports whether the download never got its metadata since
+// it was started or did not make any progress in a while, stopped
+// downloads never stall
+func (t *Tracked) stalled(d download.Torrent, now time.Time,
+	stall time.Duration, meta time.Duration) bool {
+
+	if !d.IsStarted() {
+		t.Progress = now
+		t.Started = now
+		return false
+	}
+	if d.IsMeta() {
+		started := t.Started
+		if started.IsZero() {
+			// tracked before start times were kept
+			started = t.Added
+		}
+		return now.Sub(started) > meta
+	}
+	if bytes := d.GetBytesDone(); bytes != t.Bytes || d.GetDownRate() > 0 {
+		t.Bytes = bytes
+		t.Progress = now
+		return false
+	}
+	return now.Sub(t.Progress) > stall
+}
+
+// Replace grabs the next best search result not blocked yet
+func (t *Tracked) Replace(c download.Client) *Media {
+	if t.Origin == nil {
+		return nil
+	}
+	for _, m := range rank(FindTorrentsB(t.Origin.Search, t.Origin.Tags)) {
+		if m.Get(c) || m.Pending {
+			return &m
+		}
+	}
+	return nil
+}
+
+// CheckTracked erases stalled downloads along with their data, blocks
+// their info-hash and replaces them, completed downloads are verified
+// and no longer tracked
+func (dm *Daemon) CheckTracked(c download.Client) Medias {
+
+	var (
+		ms   Medias
+		keep Trackeds
+		dead Trackeds
+		done Trackeds
+	)
+
+	now := time.Now()
+	for _, t := range loadTracked() {
+		if t.Backend != c.GetSettings().Name {
+			keep = append(keep, t)
+			continue
+		}
+		d := download.GetTorrent(c, t.Hash)
+		if d == nil {
+			continue
+		}
+		if d.IsComplete() {
+			done = append(done, t)
+			continue
+		}
+		if !t.stalled(d, now, dm.Stall, dm.MetaTimeout) {
+			keep = append(keep, t)
+			continue
+		}
+		log.Printf("stalled: %s\n", t.Name)
+		Block(t.Hash, t.Name, "stalled")
+		convertDownload(d).DelWithData()
+		dead = append(dead, t)
+	}
+	keep.save()
+
+	// replacements are tracked by Get, so only after keep is saved
+	for _, t := range dead {
+		if m := t.Replace(c); m != nil {
+			ms = append(ms, *m)
+		}
+	}
+	for _, t := range done {
+		if m := dm.onComplete(c, t); m != nil {
+			ms = append(ms, *m)
+		}
+	}
+	return ms
+}
+
+/* vim: set ts=2: */
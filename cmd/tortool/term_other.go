@@ -0,0 +1,33 @@
+//go:build !linux
+// +build !linux
+
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+)
+
+func makeRaw(fd uintptr) (func(), error) {
+	return nil, fmt.Errorf("interactive mode is only supported on linux")
+}
+
+func termSize(fd uintptr) (int, int) {
+	return 80, 24
+}
+
+/* vim: set ts=2: */
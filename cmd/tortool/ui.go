@@ -0,0 +1,341 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/filvarga/tortools/download"
+	"github.com/filvarga/tortools/search"
+)
+
+const (
+	focusInput = iota
+	focusResults
+	focusDownloads
+)
+
+// keys decoded from terminal input
+const (
+	keyNone = iota
+	keyRune
+	keyEnter
+	keyTab
+	keyBackspace
+	keyEscape
+	keyUp
+	keyDown
+	keyQuit
+)
+
+type key struct {
+	kind int
+	r    rune
+}
+
+type ui struct {
+	bs        Backends
+	tags      []string
+	focus     int
+	query     string
+	results   Medias
+	downloads Medias
+	progress  []progress
+	sel       [3]int
+	confirm   bool
+	status    string
+	out       *bufio.Writer
+}
+
+var reEpisode = regexp.MustCompile(`(?i)^(.*?)\s+s([0-9]+)e([0-9]+)$`)
+
+// parseQuery understands "title" and "title s01e02"
+func parseQuery(query string) search.Search {
+	s := search.Search{Title: strings.TrimSpace(query)}
+	if m := reEpisode.FindStringSubmatch(s.Title); m != nil {
+		s.Title = m[1]
+		s.Type = search.TV
+		s.Season = download.Str2Int(m[2], 1)
+		s.Episode = download.Str2Int(m[3], 1)
+	}
+	return s
+}
+
+func readKeys(keys chan<- key) {
+	in := bufio.NewReader(os.Stdin)
+	for {
+		r, _, err := in.ReadRune()
+		if err != nil {
+			keys <- key{kind: keyQuit}
+			return
+		}
+		switch r {
+		case 3, 4:
+			keys <- key{kind: keyQuit}
+		case '\r', '\n':
+			keys <- key{kind: keyEnter}
+		case '\t':
+			keys <- key{kind: keyTab}
+		case 127, 8:
+			keys <- key{kind: keyBackspace}
+		case 27:
+			// arrows arrive as ESC [ A|B
+			if in.Buffered() >= 2 {
+				b, _ := in.Peek(2)
+				if b[0] == '[' && (b[1] == 'A' || b[1] == 'B') {
+					in.Discard(2)
+					if b[1] == 'A' {
+						keys <- key{kind: keyUp}
+					} else {
+						keys <- key{kind: keyDown}
+					}
+					continue
+				}
+			}
+			keys <- key{kind: keyEscape}
+		default:
+			if r >= 32 {
+				keys <- key{kind: keyRune, r: r}
+			}
+		}
+	}
+}
+
+func fit(s string, width int) string {
+	rs := []rune(s)
+	if len(rs) > width {
+		return string(rs[:width])
+	}
+	return s + strings.Repeat(" ", width-len(rs))
+}
+
+func (u *ui) line(s string, selected bool, width int) {
+	if selected {
+		fmt.Fprintf(u.out, "\033[7m%s\033[0m\r\n", fit(s, width))
+	} else {
+		fmt.Fprintf(u.out, "%s\r\n", fit(s, width))
+	}
+}
+
+// rows draws a scrolled window of lines keeping sel visible
+func (u *ui) rows(lines []string, sel int, focused bool, height int, width int) {
+	offset := 0
+	if sel >= height {
+		offset = sel - height + 1
+	}
+	for i := 0; i < height; i++ {
+		if offset+i < len(lines) {
+			u.line(lines[offset+i], focused && offset+i == sel, width)
+		} else {
+			u.line("", false, width)
+		}
+	}
+}
+
+func (u *ui) draw() {
+	width, height := termSize(os.Stdout.Fd())
+	pane := (height - 5) / 2
+
+	fmt.Fprint(u.out, "\033[H\033[2J")
+
+	cursor := ""
+	if u.focus == focusInput {
+		cursor = "_"
+	}
+	u.line(fmt.Sprintf("search: %s%s", u.query, cursor), false, width)
+
+	var lines []string
+	for _, m := range u.results {
+		size := "-"
+		if n := m.torrent.GetSize(); n >= 0 {
+			size = download.Bytes2Str(n)
+		}
+		lines = append(lines, fmt.Sprintf("%-10s %5d %-20s %s", size,
+			m.torrent.Seeders, search.ParseQuality(m.Name).String(), m.Name))
+	}
+	u.line(fmt.Sprintf("%-10s %5s %-20s %s", "size", "seeds", "quality", "name"),
+		false, width)
+	u.rows(lines, u.sel[focusResults], u.focus == focusResults, pane, width)
+
+	lines = nil
+	for _, p := range u.progress {
+		lines = append(lines, fmt.Sprintf("%5.1f%% %-9s %s", p.percent, p.state,
+			u.downloads[len(lines)].String()))
+	}
+	u.line("downloads", false, width)
+	u.rows(lines, u.sel[focusDownloads], u.focus == focusDownloads, pane, width)
+
+	help := "tab: switch  enter: search/grab  p: pause  r: resume  d: delete  q: quit"
+	if len(u.status) > 0 {
+		help = u.status
+	}
+	fmt.Fprint(u.out, fit(help, width))
+	u.out.Flush()
+}
+
+func (u *ui) refresh() {
+	u.downloads = u.bs.ListDownloads("")
+	u.progress = nil
+	for _, m := range u.downloads {
+		u.progress = append(u.progress, getProgress(m.download))
+	}
+	if u.sel[focusDownloads] >= len(u.downloads) {
+		u.sel[focusDownloads] = 0
+	}
+}
+
+func (u *ui) search() {
+	u.status = "searching ..."
+	u.draw()
+	u.results = FindTorrentsB(parseQuery(u.query), u.tags)
+	u.sel[focusResults] = 0
+	u.status = fmt.Sprintf("%d results", len(u.results))
+}
+
+func (u *ui) grab() {
+	if len(u.results) == 0 {
+		return
+	}
+	m := &u.results[u.sel[focusResults]]
+	u.status = "grabbing ..."
+	u.draw()
+	switch {
+	case m.Get(u.bs.Route(m)):
+		u.status = fmt.Sprintf("grabbed: %s", m.Name)
+	case m.Pending:
+		u.status = fmt.Sprintf("deferred, low on space: %s", m.Name)
+	default:
+		u.status = fmt.Sprintf("failed: %s", m.Name)
+	}
+	u.refresh()
+}
+
+func (u *ui) act(k key) {
+	if len(u.downloads) == 0 {
+		return
+	}
+	m := u.downloads[u.sel[focusDownloads]]
+	if u.confirm {
+		u.confirm = false
+		u.status = ""
+		if k.kind == keyRune && k.r == 'y' {
+			m.download.Delete()
+			u.refresh()
+		}
+		return
+	}
+	switch k.r {
+	case 'p':
+		m.download.Pause()
+	case 'r':
+		m.download.Resume()
+		m.download.Start()
+	case 'd':
+		u.confirm = true
+		u.status = fmt.Sprintf("delete %s? y/n", m.Name)
+		return
+	}
+	u.refresh()
+}
+
+func (u *ui) move(delta int) {
+	n := len(u.results)
+	if u.focus == focusDownloads {
+		n = len(u.downloads)
+	}
+	sel := u.sel[u.focus] + delta
+	if sel >= 0 && sel < n {
+		u.sel[u.focus] = sel
+	}
+}
+
+// handle returns false once the user quits
+func (u *ui) handle(k key) bool {
+	if k.kind == keyQuit {
+		return false
+	}
+	if k.kind == keyTab {
+		u.focus = (u.focus + 1) % 3
+		return true
+	}
+	switch u.focus {
+	case focusInput:
+		switch k.kind {
+		case keyRune:
+			u.query += string(k.r)
+		case keyBackspace:
+			if rs := []rune(u.query); len(rs) > 0 {
+				u.query = string(rs[:len(rs)-1])
+			}
+		case keyEnter:
+			if len(strings.TrimSpace(u.query)) > 0 {
+				u.search()
+				u.focus = focusResults
+			}
+		case keyEscape, keyDown:
+			u.focus = focusResults
+		}
+	case focusResults, focusDownloads:
+		switch {
+		case k.kind == keyUp:
+			u.move(-1)
+		case k.kind == keyDown:
+			u.move(1)
+		case k.kind == keyRune && k.r == 'q' && !u.confirm:
+			return false
+		case k.kind == keyRune && k.r == '/':
+			u.focus = focusInput
+		case u.focus == focusResults && (k.kind == keyEnter ||
+			(k.kind == keyRune && k.r == 'g')):
+			u.grab()
+		case u.focus == focusDownloads:
+			u.act(k)
+		}
+	}
+	return true
+}
+
+// UI runs the interactive search and download manager
+func UI(bs Backends, tags []string) error {
+	restore, err := makeRaw(os.Stdin.Fd())
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	u := &ui{bs: bs, tags: tags, out: bufio.NewWriter(os.Stdout)}
+	defer func() {
+		fmt.Fprint(u.out, "\033[H\033[2J")
+		u.out.Flush()
+	}()
+
+	keys := make(chan key)
+	go readKeys(keys)
+
+	u.refresh()
+	for {
+		u.draw()
+		if !u.handle(<-keys) {
+			return nil
+		}
+	}
+}
+
+/* vim: set ts=2: */
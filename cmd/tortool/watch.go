@@ -0,0 +1,163 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/filvarga/tortools/download"
+)
+
+const barWidth = 20
+
+// exit codes of Watch
+const (
+	WatchComplete = 0
+	WatchEmpty    = 1
+	WatchErased   = 2
+)
+
+type progress struct {
+	name     string
+	percent  float64
+	done     int
+	size     int
+	down     int
+	up       int
+	peers    int
+	state    string
+	complete bool
+}
+
+func getProgress(d download.Torrent) progress {
+	p := progress{
+		name:     d.GetName(),
+		percent:  d.GetPercentDone(),
+		done:     d.GetBytesDone(),
+		size:     d.GetBytesSize(),
+		down:     d.GetDownRate(),
+		up:       d.GetUpRate(),
+		peers:    d.GetPeers(),
+		complete: d.IsComplete(),
+	}
+	switch {
+	case p.complete:
+		p.state = "complete"
+	case !d.IsStarted():
+		p.state = "stopped"
+	case d.IsMeta():
+		p.state = "metadata"
+	case !d.IsActive():
+		p.state = "paused"
+	default:
+		p.state = "leeching"
+	}
+	return p
+}
+
+func bar(percent float64) string {
+	n := int(percent / 100 * barWidth)
+	if n > barWidth {
+		n = barWidth
+	}
+	return strings.Repeat("#", n) + strings.Repeat("-", barWidth-n)
+}
+
+func eta(p progress) string {
+	if p.complete {
+		return "-"
+	}
+	if p.down <= 0 || p.size == 0 {
+		return "inf"
+	}
+	return (time.Duration((p.size-p.done)/p.down) * time.Second).String()
+}
+
+func (p progress) String() string {
+	return fmt.Sprintf("%-40.40s [%s] %5.1f%% down %9s/s up %9s/s eta %-9s peers %3d %s",
+		p.name, bar(p.percent), p.percent,
+		download.Bytes2Str(int64(p.down)), download.Bytes2Str(int64(p.up)),
+		eta(p), p.peers, p.state)
+}
+
+// Watch redraws progress of the downloads every refresh until all of
+// them complete, the returned exit code tells whether some were erased
+// in the meantime
+func Watch(bs Backends, ms Medias, refresh time.Duration) int {
+	var lines int
+
+	if len(ms) == 0 {
+		return WatchEmpty
+	}
+
+	erased := false
+	for {
+		var (
+			out      []string
+			left     Medias
+			down, up int
+			complete int
+		)
+
+		for _, m := range ms {
+			// handles of some clients are snapshots, fetch them again
+			var d download.Torrent
+			if c := bs.Get(m.Backend); c != nil {
+				d = download.GetTorrent(c, m.download.GetHash())
+			}
+			if d == nil {
+				erased = true
+				continue
+			}
+			m.download = d
+			left = append(left, m)
+
+			p := getProgress(d)
+			down += p.down
+			up += p.up
+			if p.complete {
+				complete++
+			}
+			out = append(out, p.String())
+		}
+		ms = left
+
+		out = append(out, fmt.Sprintf("total: %d/%d complete down %s/s up %s/s",
+			complete, len(ms), download.Bytes2Str(int64(down)),
+			download.Bytes2Str(int64(up))))
+
+		// redraw in place, cursor goes back to the first line
+		if lines > 0 {
+			fmt.Printf("\033[%dA\033[J", lines)
+		}
+		fmt.Println(strings.Join(out, "\n"))
+		lines = len(out)
+
+		if complete == len(ms) {
+			break
+		}
+		time.Sleep(refresh)
+	}
+
+	if erased {
+		return WatchErased
+	}
+	return WatchComplete
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,215 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/filvarga/tortools/search"
+	"github.com/filvarga/tortools/store"
+)
+
+// kinds of watchlist entries
+const (
+	WantSeries = "series"
+	WantMovie  = "movie"
+	WantTerms  = "terms"
+)
+
+// Wanted is an entry of the watchlist, releases of feeds matching it
+// are grabbed by the daemon
+type Wanted struct {
+	ID    int
+	Type  string
+	Title string
+	Year  int `json:",omitempty"`
+	// series are wanted from the episode on, zero wants all of them
+	Season  int `json:",omitempty"`
+	Episode int `json:",omitempty"`
+	// quality profile of the config, empty is the one of the profile
+	Quality string   `json:",omitempty"`
+	Tags    []string `json:",omitempty"`
+	Paused  bool     `json:",omitempty"`
+	Added   time.Time
+}
+
+type Watchlist []Wanted
+
+func LoadWatchlist() Watchlist {
+	var wl Watchlist
+	if err := store.Load("watchlist", &wl); err != nil {
+		log.Fatal(err)
+	}
+	return wl
+}
+
+func (wl Watchlist) Save() {
+	if err := store.Save("watchlist", wl); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (w *Wanted) String() string {
+	var s string
+	switch w.Type {
+	case WantSeries:
+		s = fmt.Sprintf("series: %s", w.Title)
+		if w.Episode > 0 {
+			s += fmt.Sprintf(" from s%02de%02d", w.Season, w.Episode)
+		} else if w.Season > 0 {
+			s += fmt.Sprintf(" from s%02d", w.Season)
+		}
+	case WantMovie:
+		s = fmt.Sprintf("movie:  %s", w.Title)
+		if w.Year > 0 {
+			s += fmt.Sprintf(" (%d)", w.Year)
+		}
+	default:
+		s = fmt.Sprintf("terms:  %s", w.Title)
+	}
+	if len(w.Quality) > 0 {
+		s += fmt.Sprintf(" quality: %s", w.Quality)
+	}
+	if len(w.Tags) > 0 {
+		s += fmt.Sprintf(" tags: %s", strings.Join(w.Tags, ","))
+	}
+	if w.Paused {
+		s += " [paused]"
+	}
+	return s
+}
+
+func (wl Watchlist) Show() {
+	for i, w := range wl {
+		fmt.Printf("%3d %s\n", i+1, w.String())
+	}
+}
+
+// Add appends the entry, one watched already is updated instead: its
+// season and episode are replaced, so are quality and tags when given.
+// updated tells which happened.
+func (wl Watchlist) Add(w Wanted) (out Watchlist, updated bool, err error) {
+	switch w.Type {
+	case WantSeries, WantMovie, WantTerms:
+	default:
+		return wl, false, fmt.Errorf("invalid watchlist type %s", w.Type)
+	}
+	if len(search.NormalizeTitle(w.Title)) == 0 {
+		return wl, false, fmt.Errorf("invalid watchlist title %s", w.Title)
+	}
+	id := 0
+	for i, o := range wl {
+		if o.Type == w.Type && o.Year == w.Year &&
+			search.NormalizeTitle(o.Title) == search.NormalizeTitle(w.Title) {
+			wl[i].Season, wl[i].Episode = w.Season, w.Episode
+			if len(w.Quality) > 0 {
+				wl[i].Quality = w.Quality
+			}
+			if len(w.Tags) > 0 {
+				wl[i].Tags = w.Tags
+			}
+			return wl, true, nil
+		}
+		if o.ID > id {
+			id = o.ID
+		}
+	}
+	w.ID = id + 1
+	w.Added = time.Now()
+	return append(wl, w), false, nil
+}
+
+// Remove drops the entry at position i (1 based)
+func (wl Watchlist) Remove(i int) (Watchlist, *Wanted, error) {
+	if i < 1 || i > len(wl) {
+		return wl, nil, fmt.Errorf("invalid watchlist position")
+	}
+	w := wl[i-1]
+	return append(wl[:i-1:i-1], wl[i:]...), &w, nil
+}
+
+// Pause stops (or resumes) grabbing for the entry at position i
+func (wl Watchlist) Pause(i int, paused bool) (Watchlist, error) {
+	if i < 1 || i > len(wl) {
+		return wl, fmt.Errorf("invalid watchlist position")
+	}
+	wl[i-1].Paused = paused
+	return wl, nil
+}
+
+// hasWords is whether all words of terms are words of the title
+func hasWords(title string, terms string) bool {
+	title = " " + title + " "
+	for _, w := range strings.Fields(terms) {
+		if !strings.Contains(title, " "+w+" ") {
+			return false
+		}
+	}
+	return true
+}
+
+// match returns the key a release matching the entry is grabbed by,
+// one release is grabbed per key: the episode, the movie or the release
+// itself for terms
+func (w *Wanted) match(r search.Release, name string) (string, bool) {
+	if !contains(name, w.Tags) {
+		return "", false
+	}
+	switch w.Type {
+	case WantSeries:
+		if r.Title != search.NormalizeTitle(w.Title) || r.Season < 0 ||
+			r.Episode < 0 {
+			return "", false
+		}
+		if w.Year > 0 && r.Year > 0 && w.Year != r.Year {
+			return "", false
+		}
+		if r.Season < w.Season || r.Season == w.Season && r.Episode < w.Episode {
+			return "", false
+		}
+		return fmt.Sprintf("s%02de%02d", r.Season, r.Episode), true
+	case WantMovie:
+		if r.Title != search.NormalizeTitle(w.Title) || r.Season >= 0 ||
+			(w.Year > 0 && w.Year != r.Year) {
+			return "", false
+		}
+		return WantMovie, true
+	case WantTerms:
+		title := search.NormalizeTitle(name)
+		if !hasWords(title, search.NormalizeTitle(w.Title)) {
+			return "", false
+		}
+		return title, true
+	}
+	return "", false
+}
+
+// search is what replaces stalled downloads grabbed for the entry
+func (w *Wanted) search(r search.Release) search.Search {
+	switch w.Type {
+	case WantSeries:
+		return search.Search{Type: search.TV, Title: w.Title, Season: r.Season,
+			Episode: r.Episode}
+	case WantMovie:
+		return search.Search{Type: search.Movie, Title: w.Title}
+	}
+	return search.Search{Type: search.Other, Title: w.Title}
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,321 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Endpoint is a named rtorrent instance (behind the nginx proxy) or,
+// by type, a transmission or qbittorrent one reached by url
+type Endpoint struct {
+	Type      string `json:"type,omitempty"`
+	Host      string `json:"host,omitempty"`
+	Port      int    `json:"port,omitempty"`
+	URL       string `json:"url,omitempty"`
+	User      string `json:"user,omitempty"`
+	Password  string `json:"password,omitempty"`
+	Root      string `json:"root,omitempty"`
+	Reserve   string `json:"reserve,omitempty"`
+	MaxActive int    `json:"max_active,omitempty"`
+	// categories (tv, movie, other) routed here, empty takes any
+	Categories []string `json:"categories,omitempty"`
+}
+
+// Provider is a search provider and its settings
+type Provider struct {
+	Type     string            `json:"type"`
+	URL      string            `json:"url,omitempty"`
+	APIKey   string            `json:"api_key,omitempty"`
+	Disabled bool              `json:"disabled,omitempty"`
+	Settings map[string]string `json:"settings,omitempty"`
+}
+
+// Quality is a profile of acceptable releases, empty lists accept any
+type Quality struct {
+	Resolutions []string `json:"resolutions,omitempty"`
+	Sources     []string `json:"sources,omitempty"`
+	Codecs      []string `json:"codecs,omitempty"`
+	MinSeeders  int      `json:"min_seeders,omitempty"`
+	MaxSize     string   `json:"max_size,omitempty"`
+}
+
+// Feed is an RSS or Atom feed of releases the daemon grabs watched
+// ones from
+type Feed struct {
+	URL      string `json:"url"`
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+// Library are directories completed media end up in
+type Library struct {
+	TV     string `json:"tv,omitempty"`
+	Movies string `json:"movies,omitempty"`
+}
+
+// Profile picks endpoint, quality and providers by name and may
+// override library and default tags
+type Profile struct {
+	Endpoint  string   `json:"endpoint,omitempty"`
+	Quality   string   `json:"quality,omitempty"`
+	Providers []string `json:"providers,omitempty"`
+	Library   *Library `json:"library,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+type Config struct {
+	Profile   string              `json:"profile,omitempty"`
+	Endpoints map[string]Endpoint `json:"endpoints,omitempty"`
+	Providers map[string]Provider `json:"providers,omitempty"`
+	Qualities map[string]Quality  `json:"qualities,omitempty"`
+	Profiles  map[string]Profile  `json:"profiles,omitempty"`
+	Feeds     map[string]Feed     `json:"feeds,omitempty"`
+	Library   Library             `json:"library,omitempty"`
+	Tags      []string            `json:"tags,omitempty"`
+}
+
+// Merged is the configuration in effect after the profile and the
+// environment are applied
+type Merged struct {
+	Profile   string              `json:"profile"`
+	Endpoint  Endpoint            `json:"endpoint"`
+	Endpoints map[string]Endpoint `json:"endpoints,omitempty"`
+	Quality   Quality             `json:"quality"`
+	Providers map[string]Provider `json:"providers"`
+	Library   Library             `json:"library"`
+	Tags      []string            `json:"tags"`
+}
+
+// Path is $TORTOOLS_CONFIG or $XDG_CONFIG_HOME/tortools/config.json
+func Path() string {
+	if path := os.Getenv("TORTOOLS_CONFIG"); len(path) > 0 {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, "tortools", "config.json")
+}
+
+// Load reads the config file, missing file is an empty config
+func Load(path string) (*Config, error) {
+	c := &Config{}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+// Validate checks the profiles refer to existing sections and values
+// parse, all problems are reported
+func (c *Config) Validate() []error {
+	var errs []error
+
+	if len(c.Profile) > 0 {
+		if _, ok := c.Profiles[c.Profile]; !ok {
+			errs = append(errs, fmt.Errorf("default profile %s not found", c.Profile))
+		}
+	}
+	for name, p := range c.Profiles {
+		if _, ok := c.Endpoints[p.Endpoint]; len(p.Endpoint) > 0 && !ok {
+			errs = append(errs, fmt.Errorf("profile %s: endpoint %s not found", name, p.Endpoint))
+		}
+		if _, ok := c.Qualities[p.Quality]; len(p.Quality) > 0 && !ok {
+			errs = append(errs, fmt.Errorf("profile %s: quality %s not found", name, p.Quality))
+		}
+		for _, pr := range p.Providers {
+			if _, ok := c.Providers[pr]; !ok {
+				errs = append(errs, fmt.Errorf("profile %s: provider %s not found", name, pr))
+			}
+		}
+	}
+	for name, e := range c.Endpoints {
+		switch e.Type {
+		case "", "rtorrent":
+			if len(e.Host) == 0 {
+				errs = append(errs, fmt.Errorf("endpoint %s: host missing", name))
+			}
+			if e.Port <= 0 || e.Port > 65535 {
+				errs = append(errs, fmt.Errorf("endpoint %s: invalid port %d", name, e.Port))
+			}
+		case "transmission", "qbittorrent":
+			if _, err := url.Parse(e.URL); len(e.URL) == 0 || err != nil {
+				errs = append(errs, fmt.Errorf("endpoint %s: invalid url %s", name, e.URL))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("endpoint %s: unknown type %s", name, e.Type))
+		}
+	}
+	for name, p := range c.Providers {
+		switch p.Type {
+		case "":
+			errs = append(errs, fmt.Errorf("provider %s: type missing", name))
+		case "magnetdl":
+		case "torznab":
+			if _, err := url.Parse(p.URL); len(p.URL) == 0 || err != nil {
+				errs = append(errs, fmt.Errorf("provider %s: invalid url %s", name, p.URL))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("provider %s: unknown type %s", name, p.Type))
+		}
+	}
+	for name, f := range c.Feeds {
+		if u, err := url.Parse(f.URL); len(f.URL) == 0 || err != nil ||
+			(u.Scheme != "http" && u.Scheme != "https") {
+			errs = append(errs, fmt.Errorf("feed %s: invalid url %s", name, f.URL))
+		}
+	}
+	return errs
+}
+
+// Merge applies the profile (empty means the default one) and then
+// the TORTOOLS_* environment variables. Endpoints are the backends in
+// effect: only the one the profile picks, none when TORTOOLS_HOST or
+// TORTOOLS_PORT address a single rtorrent, all of them otherwise.
+func (c *Config) Merge(profile string) (*Merged, error) {
+	var name string
+
+	m := &Merged{
+		Endpoint:  Endpoint{Host: "localhost", Port: 80},
+		Providers: c.Providers,
+		Library:   c.Library,
+		Tags:      c.Tags,
+	}
+
+	if env := os.Getenv("TORTOOLS_PROFILE"); len(profile) == 0 && len(env) > 0 {
+		profile = env
+	}
+	if len(profile) == 0 {
+		profile = c.Profile
+	}
+
+	if len(profile) > 0 {
+		p, ok := c.Profiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("profile %s not found", profile)
+		}
+		m.Profile = profile
+		if len(p.Endpoint) > 0 {
+			name = p.Endpoint
+			m.Endpoint = c.Endpoints[name]
+		}
+		if len(p.Quality) > 0 {
+			m.Quality = c.Qualities[p.Quality]
+		}
+		if len(p.Providers) > 0 {
+			m.Providers = make(map[string]Provider)
+			for _, name := range p.Providers {
+				m.Providers[name] = c.Providers[name]
+			}
+		}
+		if p.Library != nil {
+			m.Library = *p.Library
+		}
+		if len(p.Tags) > 0 {
+			m.Tags = p.Tags
+		}
+	} else if e, ok := c.Endpoints["default"]; ok {
+		name = "default"
+		m.Endpoint = e
+	}
+
+	if err := m.env(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(m.Profile) > 0 && len(name) > 0:
+		m.Endpoints = map[string]Endpoint{name: m.Endpoint}
+	case len(os.Getenv("TORTOOLS_HOST")) > 0 || len(os.Getenv("TORTOOLS_PORT")) > 0:
+	default:
+		m.Endpoints = make(map[string]Endpoint)
+		for n, e := range c.Endpoints {
+			m.Endpoints[n] = e
+		}
+		if len(name) > 0 {
+			m.Endpoints[name] = m.Endpoint
+		}
+	}
+	return m, nil
+}
+
+func (m *Merged) env() error {
+	if v := os.Getenv("TORTOOLS_HOST"); len(v) > 0 {
+		m.Endpoint.Host = v
+	}
+	if v := os.Getenv("TORTOOLS_PORT"); len(v) > 0 {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("TORTOOLS_PORT: %v", err)
+		}
+		m.Endpoint.Port = port
+	}
+	if v := os.Getenv("TORTOOLS_ROOT"); len(v) > 0 {
+		m.Endpoint.Root = v
+	}
+	if v := os.Getenv("TORTOOLS_RESERVE"); len(v) > 0 {
+		m.Endpoint.Reserve = v
+	}
+	if v := os.Getenv("TORTOOLS_TAGS"); len(v) > 0 {
+		m.Tags = strings.Split(strings.ToLower(v), ",")
+	}
+	return nil
+}
+
+// mask hides a secret, an empty one stays empty
+func mask(secret string) string {
+	if len(secret) == 0 {
+		return ""
+	}
+	return "********"
+}
+
+// String is the config in JSON with passwords and api keys masked
+func (m *Merged) String() string {
+	masked := *m
+	masked.Endpoint.Password = mask(m.Endpoint.Password)
+	masked.Endpoints = make(map[string]Endpoint)
+	for name, e := range m.Endpoints {
+		e.Password = mask(e.Password)
+		masked.Endpoints[name] = e
+	}
+	masked.Providers = make(map[string]Provider)
+	for name, p := range m.Providers {
+		p.APIKey = mask(p.APIKey)
+		masked.Providers[name] = p
+	}
+	b, err := json.MarshalIndent(&masked, "", "  ")
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,237 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package download
+
+import (
+	"log"
+	"strings"
+)
+
+// Settings are what tortools knows about any client
+type Settings struct {
+	Name       string
+	Root       string
+	Reserve    int64
+	MaxActive  int
+	Categories []string
+}
+
+// Status is a snapshot of a download
+type Status struct {
+	Hash     string
+	Name     string
+	Path     string
+	Size     int64
+	Done     int64
+	DownRate int64
+	UpRate   int64
+	Peers    int
+	Ratio    float64
+	Added    int64
+	Started  bool
+	Active   bool
+	Meta     bool
+	Complete bool
+}
+
+// Client is a BitTorrent client downloads are managed by, downloads
+// are identified by info-hash (upper case hex)
+type Client interface {
+	GetSettings() *Settings
+	List() ([]Status, error)
+	Add(magnet string, start bool) (string, error)
+	Start(hash string) error
+	Stop(hash string) error
+	Erase(hash string) error
+	// EraseWithData erases the download and its payload
+	EraseWithData(hash string) error
+	Status(hash string) (Status, error)
+	// FreeSpace is the number of bytes free in the download root
+	FreeSpace() (int64, error)
+	// Trackers are the announce URLs of the download
+	Trackers(hash string) ([]string, error)
+}
+
+var (
+	_ Client = (*Rtorrent)(nil)
+	_ Client = (*Transmission)(nil)
+	_ Client = (*QBittorrent)(nil)
+)
+
+// Torrent is a download of any client, *Download is the rtorrent one
+type Torrent interface {
+	GetHash() string
+	GetName() string
+	GetBackend() string
+	GetBytesDone() int
+	GetBytesSize() int
+	GetPercentDone() float64
+	GetDownRate() int
+	GetUpRate() int
+	GetPeers() int
+	GetRatio() float64
+	GetLoadDate() int
+	IsStarted() bool
+	IsActive() bool
+	IsMeta() bool
+	IsComplete() bool
+	Start() bool
+	Stop() bool
+	Pause() bool
+	Resume() bool
+	Delete() bool
+}
+
+var (
+	_ Torrent = (*Download)(nil)
+	_ Torrent = (*Handle)(nil)
+)
+
+// Handle is a Torrent of a client other than rtorrent, getters return
+// values of the snapshot it was created with
+type Handle struct {
+	c Client
+	s Status
+}
+
+func (h *Handle) GetHash() string         { return h.s.Hash }
+func (h *Handle) GetName() string         { return h.s.Name }
+func (h *Handle) GetBackend() string      { return h.c.GetSettings().Name }
+func (h *Handle) GetBytesDone() int       { return int(h.s.Done) }
+func (h *Handle) GetBytesSize() int       { return int(h.s.Size) }
+func (h *Handle) GetDownRate() int        { return int(h.s.DownRate) }
+func (h *Handle) GetUpRate() int          { return int(h.s.UpRate) }
+func (h *Handle) GetPeers() int           { return h.s.Peers }
+func (h *Handle) GetRatio() float64       { return h.s.Ratio }
+func (h *Handle) GetLoadDate() int        { return int(h.s.Added) }
+func (h *Handle) IsStarted() bool         { return h.s.Started }
+func (h *Handle) IsActive() bool          { return h.s.Active }
+func (h *Handle) IsMeta() bool            { return h.s.Meta }
+func (h *Handle) IsComplete() bool        { return h.s.Complete }
+func (h *Handle) GetPath() string         { return h.s.Path }
+func (h *Handle) GetPercentDone() float64 { return percent(h.s.Size, h.s.Done) }
+
+func (h *Handle) do(fn func(hash string) error) bool {
+	if err := fn(h.s.Hash); err != nil {
+		log.Println(err)
+		return false
+	}
+	return true
+}
+
+func (h *Handle) Start() bool  { return h.do(h.c.Start) }
+func (h *Handle) Stop() bool   { return h.do(h.c.Stop) }
+func (h *Handle) Pause() bool  { return h.do(h.c.Stop) }
+func (h *Handle) Resume() bool { return h.do(h.c.Start) }
+func (h *Handle) Delete() bool { return h.do(h.c.Erase) }
+
+// DeleteWithData erases the download and its payload, returns number
+// of bytes freed
+func (h *Handle) DeleteWithData() (int64, error) {
+	if err := h.c.EraseWithData(h.s.Hash); err != nil {
+		return 0, err
+	}
+	return h.s.Done, nil
+}
+
+func percent(size int64, done int64) float64 {
+	if size == 0 {
+		return 0
+	}
+	return 100 / float64(size) * float64(done)
+}
+
+// inView tells whether the download belongs to the rtorrent-like view
+func inView(s Status, view string) bool {
+	switch view {
+	case "", "main", "default", "name":
+		return true
+	case "started":
+		return s.Started
+	case "stopped":
+		return !s.Started
+	case "active":
+		return s.Active
+	case "complete":
+		return s.Complete
+	case "incomplete":
+		return !s.Complete
+	case "seeding":
+		return s.Started && s.Complete
+	case "leeching":
+		return s.Started && !s.Complete
+	case "hashing":
+		// checks aren't told apart from other states
+		return false
+	}
+	return false
+}
+
+// GetTorrents returns downloads of the client in the view
+func GetTorrents(c Client, view string) []Torrent {
+	var ts []Torrent
+
+	if r, ok := c.(*Rtorrent); ok {
+		for _, d := range r.GetDownloads(view) {
+			d := d
+			ts = append(ts, &d)
+		}
+		return ts
+	}
+
+	ss, err := c.List()
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	for _, s := range ss {
+		if inView(s, view) {
+			ts = append(ts, &Handle{c: c, s: s})
+		}
+	}
+	return ts
+}
+
+// GetTorrent returns the download or nil if the client doesn't have it
+func GetTorrent(c Client, hash string) Torrent {
+	if r, ok := c.(*Rtorrent); ok {
+		if d := r.GetDownload(hash); d != nil {
+			return d
+		}
+		return nil
+	}
+	s, err := c.Status(hash)
+	if err != nil {
+		return nil
+	}
+	return &Handle{c: c, s: s}
+}
+
+// AddTorrent adds the magnet started or stopped, nil on failure
+func AddTorrent(c Client, magnet string, start bool) Torrent {
+	hash, err := c.Add(magnet, start)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	return GetTorrent(c, hash)
+}
+
+func normalizeHash(hash string) string {
+	return strings.ToUpper(hash)
+}
+
+/* vim: set ts=2: */
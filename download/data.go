@@ -0,0 +1,229 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package download
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+func getStrValues(b []byte) []string {
+	var values []string
+
+	re := regexp.MustCompile(`String: '(?P<str>.*)'`)
+
+	matches := re.FindAllSubmatch(b, -1)
+	names := re.SubexpNames()
+
+	for _, match := range matches {
+		for i, group := range match {
+			switch names[i] {
+			case "str":
+				values = append(values, string(group))
+			}
+		}
+	}
+	return values
+}
+
+func (d *Download) IsMultiFile() bool {
+	return 1 == d.getInt64Value("d.is_multi_file")
+}
+
+func (d *Download) GetBasePath() string {
+	return d.getStrValue("d.base_path")
+}
+
+// GetFiles returns paths of the payload files relative to GetDataPath
+func (d *Download) GetFiles() []string {
+	output, err := d.r.xmlrpc("f.multicall", d.hash, "", "f.path=")
+	if err != nil {
+		log.Fatal(err)
+	}
+	return getStrValues(output)
+}
+
+// GetDataPath returns the file (single file torrent) or the directory
+// (multi file torrent) holding the payload. base_path is empty until
+// the download is opened, so fall back to the directory settings.
+func (d *Download) GetDataPath() string {
+	if path := d.GetBasePath(); len(path) > 0 {
+		return path
+	}
+	if d.IsMultiFile() {
+		return d.GetDirectory()
+	}
+	return filepath.Join(d.GetDirectory(), d.GetName())
+}
+
+// GetFilePaths returns absolute paths of the payload files
+func (d *Download) GetFilePaths() []string {
+	var paths []string
+
+	base := d.GetDataPath()
+	if !d.IsMultiFile() {
+		return []string{base}
+	}
+	for _, file := range d.GetFiles() {
+		paths = append(paths, filepath.Join(base, file))
+	}
+	return paths
+}
+
+// within reports whether path lies inside of root once all symlinks
+// of the parent directories are resolved
+func within(root string, path string) bool {
+	dir, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(root, filepath.Join(dir, filepath.Base(path)))
+	if err != nil {
+		return false
+	}
+	return rel != "." && rel != ".." &&
+		!strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// dataPaths checks the payload lies inside of root and returns its
+// files along with the directories holding them, root resolved
+func dataPaths(root string, base string, files []string, multi bool) (string, []string, []string, error) {
+	var paths, dirs []string
+
+	root, err := filepath.EvalSymlinks(filepath.Clean(root))
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	base = filepath.Clean(base)
+	if !filepath.IsAbs(base) || !within(root, base) {
+		return "", nil, nil, fmt.Errorf("data path %s is outside of %s", base, root)
+	}
+
+	if multi {
+		for _, file := range files {
+			path := filepath.Join(base, file)
+			if !strings.HasPrefix(path, base+string(filepath.Separator)) {
+				return "", nil, nil, fmt.Errorf("file path %s escapes %s", file, base)
+			}
+			paths = append(paths, path)
+			for dir := filepath.Dir(path); dir != base; dir = filepath.Dir(dir) {
+				dirs = append(dirs, dir)
+			}
+		}
+		dirs = append(dirs, base)
+	} else {
+		paths = append(paths, base)
+	}
+	// a symlinked directory of the payload may lead files out of root
+	for _, path := range paths {
+		if _, err := os.Lstat(path); err == nil && !within(root, path) {
+			return "", nil, nil, fmt.Errorf("file path %s is outside of %s", path, root)
+		}
+	}
+	return root, paths, dirs, nil
+}
+
+func removeData(root string, base string, files []string, multi bool) (int64, error) {
+	var freed int64
+
+	root, paths, dirs, err := dataPaths(root, base, files, multi)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, path := range paths {
+		// symlinks are removed, never followed
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return freed, err
+		}
+		if !within(root, path) {
+			return freed, fmt.Errorf("file path %s is outside of %s", path, root)
+		}
+		if info.IsDir() {
+			return freed, fmt.Errorf("file path %s is a directory", path)
+		}
+		if err = os.Remove(path); err != nil {
+			return freed, err
+		}
+		if info.Mode().IsRegular() {
+			freed += info.Size()
+		}
+	}
+
+	// deepest directories first, only the empty ones are removed
+	sort.Slice(dirs, func(i, j int) bool {
+		return len(dirs[i]) > len(dirs[j])
+	})
+	for _, dir := range dirs {
+		_ = os.Remove(dir)
+	}
+	return freed, nil
+}
+
+// DeleteWithData erases the download and removes its payload from the
+// disk. Nothing outside of root is ever touched, the download is kept
+// when its payload is not inside of root. Returns number of bytes
+// freed.
+func (d *Download) DeleteWithData(root string) (int64, error) {
+	base := d.GetDataPath()
+	files := d.GetFiles()
+	multi := d.IsMultiFile()
+
+	if _, _, _, err := dataPaths(root, base, files, multi); err != nil {
+		return 0, err
+	}
+	if !d.Delete() {
+		return 0, fmt.Errorf("command failed, unable to erase %s", d.hash)
+	}
+	return removeData(root, base, files, multi)
+}
+
+func (ds Downloads) DeleteWithData(root string) (int64, error) {
+	var freed int64
+	for _, d := range ds {
+		n, err := d.DeleteWithData(root)
+		freed += n
+		if err != nil {
+			return freed, err
+		}
+	}
+	return freed, nil
+}
+
+func Bytes2Str(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(n)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d %s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", value, units[i])
+}
+
+/* vim: set ts=2: */
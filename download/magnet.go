@@ -0,0 +1,85 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package download
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Magnet builds a magnet link of the info-hash, name and trackers
+func Magnet(hash string, name string, trackers []string) string {
+	magnet := "magnet:?xt=urn:btih:" + hash
+	if len(name) > 0 {
+		magnet += "&dn=" + url.QueryEscape(name)
+	}
+	for _, tr := range trackers {
+		magnet += "&tr=" + url.QueryEscape(tr)
+	}
+	return magnet
+}
+
+// MagnetSize returns the exact length (xl) of a magnet link or -1
+func MagnetSize(magnet string) int64 {
+	u, err := url.Parse(magnet)
+	if err != nil || u.Scheme != "magnet" {
+		return -1
+	}
+	if i, err := strconv.ParseInt(u.Query().Get("xl"), 10, 64); err == nil {
+		return i
+	}
+	return -1
+}
+
+// MagnetHash returns the info-hash of a magnet link in the form used
+// by rtorrent (40 upper case hex digits) or an empty string
+func MagnetHash(magnet string) string {
+	u, err := url.Parse(magnet)
+	if err != nil || u.Scheme != "magnet" {
+		return ""
+	}
+	for _, xt := range u.Query()["xt"] {
+		if !strings.HasPrefix(xt, "urn:btih:") {
+			continue
+		}
+		if hash := ParseHash(strings.TrimPrefix(xt, "urn:btih:")); len(hash) > 0 {
+			return hash
+		}
+	}
+	return ""
+}
+
+// ParseHash returns the info-hash given as 40 hex or 32 base32 digits
+// in the form used by rtorrent or an empty string
+func ParseHash(hash string) string {
+	switch len(hash) {
+	case 40:
+		if _, err := hex.DecodeString(hash); err == nil {
+			return strings.ToUpper(hash)
+		}
+	case 32:
+		b, err := base32.StdEncoding.DecodeString(strings.ToUpper(hash))
+		if err == nil {
+			return strings.ToUpper(hex.EncodeToString(b))
+		}
+	}
+	return ""
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,328 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package download
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QBittorrent talks to qBittorrent over its WebUI API (v2), URL is the
+// WebUI address (http://host:8080)
+type QBittorrent struct {
+	Settings
+	URL      string
+	User     string
+	Password string
+	HTTP     *http.Client
+
+	mu     sync.Mutex
+	client *http.Client
+}
+
+type qbittorrentTorrent struct {
+	Hash        string  `json:"hash"`
+	Name        string  `json:"name"`
+	ContentPath string  `json:"content_path"`
+	Size        int64   `json:"size"`
+	Completed   int64   `json:"completed"`
+	Progress    float64 `json:"progress"`
+	DlSpeed     int64   `json:"dlspeed"`
+	UpSpeed     int64   `json:"upspeed"`
+	NumSeeds    int     `json:"num_seeds"`
+	NumLeechs   int     `json:"num_leechs"`
+	Ratio       float64 `json:"ratio"`
+	AddedOn     int64   `json:"added_on"`
+	State       string  `json:"state"`
+}
+
+func (qb *QBittorrent) GetSettings() *Settings {
+	return &qb.Settings
+}
+
+// session returns the client keeping the session cookie
+func (qb *QBittorrent) session() *http.Client {
+	qb.mu.Lock()
+	defer qb.mu.Unlock()
+
+	if qb.client == nil {
+		c := http.Client{Timeout: httpTimeout}
+		if qb.HTTP != nil {
+			c = *qb.HTTP
+		}
+		if c.Jar == nil {
+			c.Jar, _ = cookiejar.New(nil)
+		}
+		qb.client = &c
+	}
+	return qb.client
+}
+
+func (qb *QBittorrent) post(path string, form url.Values) (*http.Response, error) {
+	req, err := http.NewRequest("POST", strings.TrimSuffix(qb.URL, "/")+path,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// CSRF protection of the WebUI checks the referer
+	req.Header.Set("Referer", qb.URL)
+	return qb.session().Do(req)
+}
+
+func (qb *QBittorrent) login() error {
+	resp, err := qb.post("/api/v2/auth/login", url.Values{
+		"username": {qb.User},
+		"password": {qb.Password},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || strings.TrimSpace(string(body)) != "Ok." {
+		return fmt.Errorf("qbittorrent login failed, %s %s", resp.Status,
+			strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// call posts the API method, logging in whenever the session is gone
+func (qb *QBittorrent) call(method string, form url.Values) ([]byte, error) {
+	path := "/api/v2/" + method
+
+	resp, err := qb.post(path, form)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusForbidden {
+		resp.Body.Close()
+		if err = qb.login(); err != nil {
+			return nil, err
+		}
+		if resp, err = qb.post(path, form); err != nil {
+			return nil, err
+		}
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return body, &qbittorrentError{method, resp.StatusCode, resp.Status}
+	}
+	return body, nil
+}
+
+type qbittorrentError struct {
+	method string
+	code   int
+	status string
+}
+
+func (e *qbittorrentError) Error() string {
+	return fmt.Sprintf("qbittorrent %s failed, %s", e.method, e.status)
+}
+
+func (t *qbittorrentTorrent) status() Status {
+	var started bool
+
+	switch t.State {
+	case "pausedDL", "pausedUP", "stoppedDL", "stoppedUP", "error", "missingFiles":
+	default:
+		started = true
+	}
+	return Status{
+		Hash:     normalizeHash(t.Hash),
+		Name:     t.Name,
+		Path:     t.ContentPath,
+		Size:     t.Size,
+		Done:     t.Completed,
+		DownRate: t.DlSpeed,
+		UpRate:   t.UpSpeed,
+		Peers:    t.NumSeeds + t.NumLeechs,
+		Ratio:    t.Ratio,
+		Added:    t.AddedOn,
+		Started:  started,
+		Active:   started && !strings.HasPrefix(t.State, "queued"),
+		Meta:     t.State == "metaDL" || t.State == "forcedMetaDL",
+		Complete: t.Progress >= 1,
+	}
+}
+
+func (qb *QBittorrent) info(hash string) ([]Status, error) {
+	var (
+		ss   []Status
+		form = url.Values{}
+		ts   []qbittorrentTorrent
+	)
+
+	if len(hash) > 0 {
+		form.Set("hashes", strings.ToLower(hash))
+	}
+	body, err := qb.call("torrents/info", form)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(body, &ts); err != nil {
+		return nil, fmt.Errorf("qbittorrent torrents/info failed, %v", err)
+	}
+	for _, t := range ts {
+		ss = append(ss, t.status())
+	}
+	return ss, nil
+}
+
+func (qb *QBittorrent) List() ([]Status, error) {
+	return qb.info("")
+}
+
+func (qb *QBittorrent) Status(hash string) (Status, error) {
+	ss, err := qb.info(hash)
+	if err != nil {
+		return Status{}, err
+	}
+	if len(ss) == 0 {
+		return Status{}, fmt.Errorf("download %s not found", hash)
+	}
+	return ss[0], nil
+}
+
+// Add adds the magnet, qBittorrent doesn't return the download so the
+// hash is taken from the magnet and waited for
+func (qb *QBittorrent) Add(magnet string, start bool) (string, error) {
+	retries := 3
+
+	hash := MagnetHash(magnet)
+	if len(hash) == 0 {
+		return "", fmt.Errorf("magnet %s has no info-hash", magnet)
+	}
+
+	paused := fmt.Sprint(!start)
+	body, err := qb.call("torrents/add", url.Values{
+		"urls":    {magnet},
+		"paused":  {paused},
+		"stopped": {paused},
+	})
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(string(body)) != "Ok." {
+		return "", fmt.Errorf("qbittorrent torrents/add failed, %s",
+			strings.TrimSpace(string(body)))
+	}
+
+	for i := 0; i < retries; i++ {
+		if _, err = qb.Status(hash); err == nil {
+			return hash, nil
+		}
+		time.Sleep(time.Second)
+	}
+	return "", err
+}
+
+// action calls method of API 2.11 (qBittorrent 5) and falls back to the
+// older name
+func (qb *QBittorrent) action(method string, old string, hash string) error {
+	form := url.Values{"hashes": {strings.ToLower(hash)}}
+	_, err := qb.call("torrents/"+method, form)
+	if e, ok := err.(*qbittorrentError); ok && e.code == http.StatusNotFound {
+		_, err = qb.call("torrents/"+old, form)
+	}
+	return err
+}
+
+func (qb *QBittorrent) Start(hash string) error {
+	return qb.action("start", "resume", hash)
+}
+
+func (qb *QBittorrent) Stop(hash string) error {
+	return qb.action("stop", "pause", hash)
+}
+
+// FreeSpace is what qBittorrent tells about the disk of its default
+// save path
+func (qb *QBittorrent) FreeSpace() (int64, error) {
+	var data struct {
+		ServerState struct {
+			FreeSpaceOnDisk int64 `json:"free_space_on_disk"`
+		} `json:"server_state"`
+	}
+
+	body, err := qb.call("sync/maindata", url.Values{})
+	if err != nil {
+		return 0, err
+	}
+	if err = json.Unmarshal(body, &data); err != nil {
+		return 0, fmt.Errorf("qbittorrent sync/maindata failed, %v", err)
+	}
+	return data.ServerState.FreeSpaceOnDisk, nil
+}
+
+// Trackers leaves out the ** [DHT] **, ** [PeX] ** and ** [LSD] **
+// entries
+func (qb *QBittorrent) Trackers(hash string) ([]string, error) {
+	var (
+		urls     []string
+		trackers []struct {
+			URL string `json:"url"`
+		}
+	)
+
+	body, err := qb.call("torrents/trackers",
+		url.Values{"hash": {strings.ToLower(hash)}})
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(body, &trackers); err != nil {
+		return nil, fmt.Errorf("qbittorrent torrents/trackers failed, %v", err)
+	}
+	for _, t := range trackers {
+		if !strings.HasPrefix(t.URL, "**") {
+			urls = append(urls, t.URL)
+		}
+	}
+	return urls, nil
+}
+
+func (qb *QBittorrent) delete(hash string, files bool) error {
+	_, err := qb.call("torrents/delete", url.Values{
+		"hashes":      {strings.ToLower(hash)},
+		"deleteFiles": {fmt.Sprint(files)},
+	})
+	return err
+}
+
+// Erase removes the download, the payload stays on the disk
+func (qb *QBittorrent) Erase(hash string) error {
+	return qb.delete(hash, false)
+}
+
+func (qb *QBittorrent) EraseWithData(hash string) error {
+	return qb.delete(hash, true)
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,209 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package download
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// fakeQBittorrent answers the WebUI API methods tortools uses, only
+// API 2.11 names when legacy is false, only the older ones otherwise
+type fakeQBittorrent struct {
+	legacy bool
+	logins int
+	calls  []string
+	forms  []url.Values
+}
+
+func (f *fakeQBittorrent) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	method := req.URL.Path[len("/api/v2/"):]
+	req.ParseForm()
+
+	if method == "auth/login" {
+		if req.PostForm.Get("password") != "secret" {
+			fmt.Fprint(w, "Fails.")
+			return
+		}
+		f.logins++
+		http.SetCookie(w, &http.Cookie{Name: "SID", Value: "sid", Path: "/"})
+		fmt.Fprint(w, "Ok.")
+		return
+	}
+	if c, err := req.Cookie("SID"); err != nil || c.Value != "sid" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	f.calls = append(f.calls, method)
+	f.forms = append(f.forms, req.PostForm)
+
+	switch method {
+	case "torrents/info":
+		json.NewEncoder(w).Encode([]map[string]interface{}{{
+			"hash":         "0123456789abcdef0123456789abcdef01234567",
+			"name":         "a",
+			"content_path": "/downloads/a",
+			"size":         100,
+			"completed":    100,
+			"progress":     1,
+			"num_seeds":    2,
+			"num_leechs":   1,
+			"state":        "uploading",
+		}})
+	case "torrents/add":
+		fmt.Fprint(w, "Ok.")
+	case "torrents/trackers":
+		json.NewEncoder(w).Encode([]map[string]string{
+			{"url": "** [DHT] **"},
+			{"url": "udp://tracker.example:1337/announce"},
+		})
+	case "sync/maindata":
+		fmt.Fprint(w, `{"server_state":{"free_space_on_disk":2048}}`)
+	case "torrents/start", "torrents/stop":
+		if f.legacy {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case "torrents/resume", "torrents/pause":
+		if !f.legacy {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case "torrents/delete":
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newQBittorrent(t *testing.T) (*QBittorrent, *fakeQBittorrent) {
+	f := &fakeQBittorrent{}
+	s := httptest.NewServer(f)
+	t.Cleanup(s.Close)
+	return &QBittorrent{Settings: Settings{Name: "test"}, URL: s.URL,
+		User: "admin", Password: "secret"}, f
+}
+
+func TestQBittorrentLogin(t *testing.T) {
+	qb, f := newQBittorrent(t)
+
+	if _, err := qb.List(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := qb.List(); err != nil {
+		t.Fatal(err)
+	}
+	if f.logins != 1 {
+		t.Fatalf("%d logins", f.logins)
+	}
+
+	qb.Password = "wrong"
+	qb.client = nil
+	if _, err := qb.List(); err == nil {
+		t.Fatal("wrong password accepted")
+	}
+}
+
+func TestQBittorrentStatus(t *testing.T) {
+	qb, f := newQBittorrent(t)
+
+	s, err := qb.Status(hashA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Hash != hashA || s.Path != "/downloads/a" || s.Peers != 3 ||
+		!s.Started || !s.Active || s.Meta || !s.Complete {
+		t.Fatalf("status %+v", s)
+	}
+	if f.forms[0].Get("hashes") != "0123456789abcdef0123456789abcdef01234567" {
+		t.Fatalf("form %v", f.forms[0])
+	}
+}
+
+func TestQBittorrentAdd(t *testing.T) {
+	qb, f := newQBittorrent(t)
+
+	magnet := "magnet:?xt=urn:btih:" + hashA
+	hash, err := qb.Add(magnet, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != hashA || f.calls[0] != "torrents/add" ||
+		f.forms[0].Get("urls") != magnet || f.forms[0].Get("paused") != "true" {
+		t.Fatalf("hash %s, calls %v %v", hash, f.calls, f.forms)
+	}
+}
+
+func TestQBittorrentLegacyActions(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		qb, f := newQBittorrent(t)
+		f.legacy = legacy
+
+		if err := qb.Start(hashA); err != nil {
+			t.Fatal(err)
+		}
+		if err := qb.Stop(hashA); err != nil {
+			t.Fatal(err)
+		}
+		last := f.calls[len(f.calls)-1]
+		if (legacy && last != "torrents/pause") || (!legacy && last != "torrents/stop") {
+			t.Fatalf("legacy %v, calls %v", legacy, f.calls)
+		}
+	}
+}
+
+func TestQBittorrentErase(t *testing.T) {
+	qb, f := newQBittorrent(t)
+
+	if err := qb.Erase(hashA); err != nil {
+		t.Fatal(err)
+	}
+	if err := qb.EraseWithData(hashA); err != nil {
+		t.Fatal(err)
+	}
+	for i, files := range []string{"false", "true"} {
+		if f.calls[i] != "torrents/delete" || f.forms[i].Get("deleteFiles") != files {
+			t.Fatalf("call %s %v", f.calls[i], f.forms[i])
+		}
+	}
+}
+
+func TestQBittorrentFreeSpace(t *testing.T) {
+	qb, _ := newQBittorrent(t)
+
+	free, err := qb.FreeSpace()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if free != 2048 {
+		t.Fatalf("free %d", free)
+	}
+}
+
+func TestQBittorrentTrackers(t *testing.T) {
+	qb, _ := newQBittorrent(t)
+
+	trackers, err := qb.Trackers(hashA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trackers) != 1 || trackers[0] != "udp://tracker.example:1337/announce" {
+		t.Fatalf("trackers %v", trackers)
+	}
+}
+
+/* vim: set ts=2: */
@@ -16,18 +16,24 @@
 package download
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/filvarga/tortools/run"
 )
 
+const xmlrpcTimeout = 30 * time.Second
+
 type Rtorrent struct {
-	Host string
-	Port int
+	Settings
+	Host   string
+	Port   int
+	Runner run.Runner
 }
 
 type Download struct {
@@ -44,7 +50,7 @@ func (r *Rtorrent) getInt64Value(field string) int {
 		value  int
 	)
 
-	output, err = run.Exec2("xmlrpc", r.url(), field)
+	output, err = r.xmlrpc(field)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -61,15 +67,31 @@ func (r *Rtorrent) url() string {
 	return fmt.Sprintf("%s:%d", r.Host, r.Port)
 }
 
+func (r *Rtorrent) xmlrpc(args ...string) ([]byte, error) {
+	c := run.New("xmlrpc", append([]string{r.url()}, args...)...).
+		Combined().Timeout(xmlrpcTimeout)
+	res, err := run.Or(r.Runner).Run(context.Background(), c)
+	return res.Stdout, err
+}
+
 func (r *Rtorrent) GetSystemTime() int {
 	return r.getInt64Value("system.time")
 }
 
-func (r *Rtorrent) GetDownloads() Downloads {
+// GetDownloads returns downloads of the view, empty view lists all
+func (r *Rtorrent) GetDownloads(view string) Downloads {
 
-	var downloads []Download
+	var (
+		downloads []Download
+		output    []byte
+		err       error
+	)
 
-	output, err := run.Exec2("xmlrpc", r.url(), "download_list")
+	if len(view) > 0 {
+		output, err = r.xmlrpc("download_list", "", view)
+	} else {
+		output, err = r.xmlrpc("download_list")
+	}
 	if err != nil {
 		log.Fatal("error getting xmlrpc result")
 	}
@@ -90,10 +112,28 @@ func (r *Rtorrent) GetDownloads() Downloads {
 	return downloads
 }
 
+func (r *Rtorrent) GetDownload(hash string) *Download {
+	for _, d := range r.GetDownloads("") {
+		if d.hash == hash {
+			return &d
+		}
+	}
+	return nil
+}
+
 func (r *Rtorrent) TryCreateDownload(magnet string) bool {
+	return r.tryLoad("load.start", magnet)
+}
+
+// TryLoadDownload creates the download stopped
+func (r *Rtorrent) TryLoadDownload(magnet string) bool {
+	return r.tryLoad("load.normal", magnet)
+}
+
+func (r *Rtorrent) tryLoad(method string, magnet string) bool {
 	var value int
 
-	output, err := run.Exec2("xmlrpc", r.url(), "load.start", "", magnet)
+	output, err := r.xmlrpc(method, "", magnet)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -128,6 +168,15 @@ func diffDownloads(dn Downloads, do Downloads) Downloads {
 }
 
 func (r *Rtorrent) AddDownload(magnet string) *Download {
+	return r.addDownload("load.start", magnet)
+}
+
+// LoadDownload adds the download without starting it
+func (r *Rtorrent) LoadDownload(magnet string) *Download {
+	return r.addDownload("load.normal", magnet)
+}
+
+func (r *Rtorrent) addDownload(method string, magnet string) *Download {
 
 	// this isn't bulletproof, if for instance some other
 	// tool or rtorrent (from watch dir) itself adds
@@ -135,17 +184,17 @@ func (r *Rtorrent) AddDownload(magnet string) *Download {
 
 	retries := 2
 
-	old := r.GetDownloads()
+	old := r.GetDownloads("")
 	t1 := r.GetSystemTime()
 
-	if !r.TryCreateDownload(magnet) {
+	if !r.tryLoad(method, magnet) {
 		return nil
 	}
 
 	t2 := r.GetSystemTime()
 
 	for i := 0; i < retries; i++ {
-		diff := diffDownloads(r.GetDownloads(), old)
+		diff := diffDownloads(r.GetDownloads(""), old)
 		if len(diff) > 1 {
 			log.Fatal("error multiple new downloads found")
 		}
@@ -162,6 +211,112 @@ func (r *Rtorrent) AddDownload(magnet string) *Download {
 	return nil
 }
 
+func (r *Rtorrent) GetSettings() *Settings {
+	return &r.Settings
+}
+
+// FreeSpace asks the filesystem of the download root, rtorrent doesn't
+// tell, so the root has to be mounted where tortool runs
+func (r *Rtorrent) FreeSpace() (int64, error) {
+	return FreeSpace(r.Root)
+}
+
+func (d *Download) status() Status {
+	return Status{
+		Hash:     d.hash,
+		Name:     d.GetName(),
+		Path:     d.GetDataPath(),
+		Size:     int64(d.GetBytesSize()),
+		Done:     int64(d.GetBytesDone()),
+		DownRate: int64(d.GetDownRate()),
+		UpRate:   int64(d.GetUpRate()),
+		Peers:    d.GetPeers(),
+		Ratio:    d.GetRatio(),
+		Added:    int64(d.GetLoadDate()),
+		Started:  d.IsStarted(),
+		Active:   d.IsActive(),
+		Meta:     d.IsMeta(),
+		Complete: d.IsComplete(),
+	}
+}
+
+func (r *Rtorrent) List() ([]Status, error) {
+	var ss []Status
+	for _, d := range r.GetDownloads("") {
+		ss = append(ss, d.status())
+	}
+	return ss, nil
+}
+
+func (r *Rtorrent) Add(magnet string, start bool) (string, error) {
+	method := "load.normal"
+	if start {
+		method = "load.start"
+	}
+	d := r.addDownload(method, magnet)
+	if d == nil {
+		return "", fmt.Errorf("command failed, unable to add %s", magnet)
+	}
+	return d.hash, nil
+}
+
+func (r *Rtorrent) do(hash string, what string, fn func(d *Download) bool) error {
+	d := r.GetDownload(normalizeHash(hash))
+	if d == nil {
+		return fmt.Errorf("download %s not found", hash)
+	}
+	if !fn(d) {
+		return fmt.Errorf("command failed, unable to %s %s", what, hash)
+	}
+	return nil
+}
+
+func (r *Rtorrent) Start(hash string) error {
+	return r.do(hash, "start", (*Download).Start)
+}
+
+func (r *Rtorrent) Stop(hash string) error {
+	return r.do(hash, "stop", (*Download).Stop)
+}
+
+func (r *Rtorrent) Erase(hash string) error {
+	return r.do(hash, "erase", (*Download).Delete)
+}
+
+// EraseWithData removes the payload only when it is inside Root
+func (r *Rtorrent) EraseWithData(hash string) error {
+	d := r.GetDownload(normalizeHash(hash))
+	if d == nil {
+		return fmt.Errorf("download %s not found", hash)
+	}
+	_, err := d.DeleteWithData(r.Root)
+	return err
+}
+
+// Trackers leaves out the dht:// pseudo tracker
+func (r *Rtorrent) Trackers(hash string) ([]string, error) {
+	var urls []string
+
+	output, err := r.xmlrpc("t.multicall", normalizeHash(hash), "", "t.url=")
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range getStrValues(output) {
+		if !strings.HasPrefix(u, "dht://") {
+			urls = append(urls, u)
+		}
+	}
+	return urls, nil
+}
+
+func (r *Rtorrent) Status(hash string) (Status, error) {
+	d := r.GetDownload(normalizeHash(hash))
+	if d == nil {
+		return Status{}, fmt.Errorf("download %s not found", hash)
+	}
+	return d.status(), nil
+}
+
 func (ds Downloads) Resume() {
 	for _, d := range ds {
 		d.Resume()
@@ -274,7 +429,7 @@ func (d *Download) getStrValue(field string) string {
 		value  string
 	)
 
-	output, err = run.Exec2("xmlrpc", d.r.url(), field, d.hash)
+	output, err = d.r.xmlrpc(field, d.hash)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -294,7 +449,7 @@ func (d *Download) getIntValue(field string) int {
 		value  int
 	)
 
-	output, err = run.Exec2("xmlrpc", d.r.url(), field, d.hash)
+	output, err = d.r.xmlrpc(field, d.hash)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -314,7 +469,7 @@ func (d *Download) getInt64Value(field string) int {
 		value  int
 	)
 
-	output, err = run.Exec2("xmlrpc", d.r.url(), field, d.hash)
+	output, err = d.r.xmlrpc(field, d.hash)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -327,16 +482,35 @@ func (d *Download) getInt64Value(field string) int {
 	return value
 }
 
+func (d *Download) GetHash() string {
+	return d.hash
+}
+
+// GetBackend returns name of the rtorrent the download belongs to
+func (d *Download) GetBackend() string {
+	return d.r.Name
+}
+
+// GetRoot returns download root of the rtorrent
+func (d *Download) GetRoot() string {
+	return d.r.Root
+}
+
 func (d *Download) IsActive() bool {
-	return 0 == d.getInt64Value("d.is_active")
+	return 1 == d.getInt64Value("d.is_active")
 }
 
 func (d *Download) IsStarted() bool {
 	return 1 == d.getInt64Value("d.state")
 }
 
+// IsMeta reports whether the download still waits for magnet metadata
+func (d *Download) IsMeta() bool {
+	return 1 == d.getInt64Value("d.is_meta")
+}
+
 func (d *Download) IsComplete() bool {
-	return 0 == d.getInt64Value("d.complete")
+	return 1 == d.getInt64Value("d.complete")
 }
 
 func (d *Download) Resume() bool {
@@ -375,6 +549,10 @@ func (d *Download) GetLeechers() int {
 	return Str2Int(d.getStrValue("d.connection_leech"), -1)
 }
 
+func (d *Download) GetPeers() int {
+	return d.getInt64Value("d.peers_connected")
+}
+
 func (d *Download) GetBytesDone() int {
 	return d.getInt64Value("d.bytes_done")
 }
@@ -383,12 +561,30 @@ func (d *Download) GetBytesSize() int {
 	return d.getInt64Value("d.size_bytes")
 }
 
+func (d *Download) GetDownRate() int {
+	return d.getInt64Value("d.down.rate")
+}
+
+func (d *Download) GetUpRate() int {
+	return d.getInt64Value("d.up.rate")
+}
+
+// GetRatio returns upload to download ratio
+func (d *Download) GetRatio() float64 {
+	return float64(d.getInt64Value("d.ratio")) / 1000
+}
+
 func (d *Download) GetLoadDate() int {
 	return d.getInt64Value("d.load_date")
 }
 
 func (d *Download) GetPercentDone() float64 {
-	return 100 / float64(d.GetBytesSize()) *
+	size := d.GetBytesSize()
+	if size == 0 {
+		// magnet without metadata yet
+		return 0
+	}
+	return 100 / float64(size) *
 		float64(d.GetBytesDone())
 }
 
@@ -0,0 +1,311 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package download
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/filvarga/tortools/run"
+)
+
+const (
+	hashA = "0123456789ABCDEF0123456789ABCDEF01234567"
+	hashB = "89ABCDEF0123456789ABCDEF0123456789ABCDEF"
+)
+
+// replies as printed by the xmlrpc command of xmlrpc-c
+func xmlList(hashes ...string) string {
+	out := fmt.Sprintf("Result:\n\nArray of %d items:\n", len(hashes))
+	for i, h := range hashes {
+		out += fmt.Sprintf("  Index %2d String: '%s'\n", i, h)
+	}
+	return out
+}
+
+func xmlInt(i int) string {
+	return fmt.Sprintf("Result:\n\nInteger: %d\n", i)
+}
+
+func xmlInt64(i int) string {
+	return fmt.Sprintf("Result:\n\n64-bit integer: %d\n", i)
+}
+
+func xmlStr(s string) string {
+	return fmt.Sprintf("Result:\n\nString: '%s'\n", s)
+}
+
+func newFake() (*Rtorrent, *run.Fake) {
+	f := &run.Fake{}
+	return &Rtorrent{Settings: Settings{Name: "test"}, Host: "localhost",
+		Port: 80, Runner: f}, f
+}
+
+// called reports whether some command run had all of args
+func called(f *run.Fake, args ...string) bool {
+	for _, argv := range f.Calls() {
+		line := strings.Join(argv, " ")
+		found := true
+		for _, a := range args {
+			if !strings.Contains(line, a) {
+				found = false
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+func TestGetDownloads(t *testing.T) {
+	r, f := newFake()
+	f.On(`download_list$`).Reply(xmlList(hashA, hashB), 0)
+	f.On(`download_list  started$`).Reply(xmlList(hashB), 0)
+
+	ds := r.GetDownloads("")
+	if len(ds) != 2 || ds[0].GetHash() != hashA || ds[1].GetHash() != hashB {
+		t.Fatalf("unexpected downloads %v", ds)
+	}
+	ds = r.GetDownloads("started")
+	if len(ds) != 1 || ds[0].GetHash() != hashB {
+		t.Fatalf("unexpected downloads of view %v", ds)
+	}
+	if d := r.GetDownload(hashB); d == nil {
+		t.Fatal("download not found")
+	}
+	if d := r.GetDownload("NONE"); d != nil {
+		t.Fatal("unknown download found")
+	}
+}
+
+func TestAddTorrent(t *testing.T) {
+	magnet := "magnet:?xt=urn:btih:" + hashB
+
+	r, f := newFake()
+	// the new download shows up once loaded, between the two times
+	f.On(`download_list$`).Reply(xmlList(hashA), 0).Then(xmlList(hashA, hashB), 0)
+	f.On(`system.time`).Reply(xmlInt64(100), 0).Then(xmlInt64(200), 0)
+	f.On(`load.start`).Reply(xmlInt(0), 0)
+	f.On(`d.load_date `+hashB).Reply(xmlInt64(150), 0)
+
+	d := AddTorrent(r, magnet, true)
+	if d == nil {
+		t.Fatal("download not added")
+	}
+	if d.GetHash() != hashB {
+		t.Fatalf("added %s, expected %s", d.GetHash(), hashB)
+	}
+	if !called(f, "load.start", magnet) {
+		t.Fatal("magnet not loaded")
+	}
+	if called(f, "load.normal") {
+		t.Fatal("started download loaded stopped")
+	}
+}
+
+func TestAddTorrentStopped(t *testing.T) {
+	r, f := newFake()
+	f.On(`download_list$`).Reply(xmlList(), 0).Then(xmlList(hashA), 0)
+	f.On(`system.time`).Reply(xmlInt64(100), 0).Then(xmlInt64(200), 0)
+	f.On(`load.normal`).Reply(xmlInt(0), 0)
+	f.On(`d.load_date `+hashA).Reply(xmlInt64(150), 0)
+
+	if d := AddTorrent(r, "magnet:?xt=urn:btih:"+hashA, false); d == nil {
+		t.Fatal("download not added")
+	}
+	if called(f, "load.start") {
+		t.Fatal("queued download started")
+	}
+}
+
+func TestDelete(t *testing.T) {
+	r, f := newFake()
+	f.On(`download_list$`).Reply(xmlList(hashA), 0)
+	f.On(`d.erase `+hashA).Reply(xmlInt(0), 0)
+
+	d := r.GetDownload(hashA)
+	if d == nil || !d.Delete() {
+		t.Fatal("download not deleted")
+	}
+	if !called(f, "d.erase", hashA) {
+		t.Fatal("download not erased")
+	}
+	if err := r.Erase(hashA); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Erase(hashB); err == nil {
+		t.Fatal("unknown download erased")
+	}
+}
+
+func TestState(t *testing.T) {
+	r, f := newFake()
+	f.On(`download_list$`).Reply(xmlList(hashA, hashB), 0)
+	f.On(`d.is_active `+hashA).Reply(xmlInt64(1), 0)
+	f.On(`d.complete `+hashA).Reply(xmlInt64(1), 0)
+	f.On(`d.is_active `+hashB).Reply(xmlInt64(0), 0)
+	f.On(`d.complete `+hashB).Reply(xmlInt64(0), 0)
+
+	if d := r.GetDownload(hashA); !d.IsActive() || !d.IsComplete() {
+		t.Fatal("active and complete download reported otherwise")
+	}
+	if d := r.GetDownload(hashB); d.IsActive() || d.IsComplete() {
+		t.Fatal("inactive and incomplete download reported otherwise")
+	}
+}
+
+func TestDeleteWithData(t *testing.T) {
+	root := t.TempDir()
+	base := filepath.Join(root, "Show.S01E01")
+	if err := os.MkdirAll(base, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(base, "video.mkv"), []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, f := newFake()
+	f.On(`download_list$`).Reply(xmlList(hashA), 0)
+	f.On(`d.base_path`).Reply(xmlStr(base), 0)
+	f.On(`d.is_multi_file`).Reply(xmlInt64(1), 0)
+	f.On(`f.multicall`).Reply(xmlList("video.mkv"), 0)
+	f.On(`d.erase`).Reply(xmlInt(0), 0)
+
+	// payload outside of root keeps the download
+	d := r.GetDownload(hashA)
+	if _, err := d.DeleteWithData(t.TempDir()); err == nil {
+		t.Fatal("payload outside of root deleted")
+	}
+	if called(f, "d.erase") {
+		t.Fatal("download erased though its payload is kept")
+	}
+
+	freed, err := d.DeleteWithData(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if freed != 5 {
+		t.Fatalf("freed %d bytes, expected 5", freed)
+	}
+	if _, err := os.Stat(base); !os.IsNotExist(err) {
+		t.Fatal("payload not removed")
+	}
+}
+
+func TestDeleteWithDataSymlinks(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "secret")
+	if err := ioutil.WriteFile(secret, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the base directory links out of root, the download is kept
+	base := filepath.Join(root, "Linked")
+	if err := os.Symlink(outside, base); err != nil {
+		t.Fatal(err)
+	}
+	r, f := newFake()
+	f.On(`download_list$`).Reply(xmlList(hashA), 0)
+	f.On(`d.base_path`).Reply(xmlStr(base), 0)
+	f.On(`d.is_multi_file`).Reply(xmlInt64(1), 0)
+	f.On(`f.multicall`).Reply(xmlList("secret"), 0)
+	f.On(`d.erase`).Reply(xmlInt(0), 0)
+
+	if _, err := r.GetDownload(hashA).DeleteWithData(root); err == nil {
+		t.Fatal("payload of a symlinked directory deleted")
+	}
+	if called(f, "d.erase") {
+		t.Fatal("download erased though its payload is kept")
+	}
+	if _, err := os.Stat(secret); err != nil {
+		t.Fatal("file outside of root removed")
+	}
+
+	// a file linking out of root is removed, its target is not
+	base = filepath.Join(root, "Show.S01E01")
+	if err := os.MkdirAll(base, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(base, "video.mkv"), []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(base, "secret")); err != nil {
+		t.Fatal(err)
+	}
+	r, f = newFake()
+	f.On(`download_list$`).Reply(xmlList(hashA), 0)
+	f.On(`d.base_path`).Reply(xmlStr(base), 0)
+	f.On(`d.is_multi_file`).Reply(xmlInt64(1), 0)
+	f.On(`f.multicall`).Reply(xmlList("video.mkv", "secret"), 0)
+	f.On(`d.erase`).Reply(xmlInt(0), 0)
+
+	freed, err := r.GetDownload(hashA).DeleteWithData(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if freed != 5 {
+		t.Fatalf("freed %d bytes, expected 5", freed)
+	}
+	if _, err := os.Lstat(base); !os.IsNotExist(err) {
+		t.Fatal("payload not removed")
+	}
+	if b, err := ioutil.ReadFile(secret); err != nil || string(b) != "secret" {
+		t.Fatal("symlink target outside of root removed")
+	}
+}
+
+func TestTrackers(t *testing.T) {
+	r, f := newFake()
+	f.On(`t.multicall`).Reply(xmlList("udp://tracker.example:1337/announce",
+		"dht://"), 0)
+
+	trackers, err := r.Trackers(hashA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trackers) != 1 || trackers[0] != "udp://tracker.example:1337/announce" {
+		t.Fatalf("trackers %v", trackers)
+	}
+	magnet := Magnet(hashA, "a b", trackers)
+	if MagnetHash(magnet) != hashA ||
+		!strings.Contains(magnet, "&dn=a+b&tr=udp%3A%2F%2Ftracker.example") {
+		t.Fatalf("magnet %s", magnet)
+	}
+}
+
+func TestHasView(t *testing.T) {
+	r, f := newFake()
+	f.On(`view.list`).Reply(xmlList("main", "started", "mine"), 0)
+
+	for view, want := range map[string]bool{
+		"": true, "started": true, "mine": true, "bogus": false,
+	} {
+		if HasView(r, view) != want {
+			t.Fatalf("view %q expected %v", view, want)
+		}
+	}
+	if HasView(&Transmission{}, "mine") || !HasView(&Transmission{}, "seeding") {
+		t.Fatal("views of other clients are the built in ones")
+	}
+}
+
+/* vim: set ts=2: */
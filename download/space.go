@@ -0,0 +1,74 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package download
+
+import (
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// HasSpace reports whether size bytes fit into the download root of
+// the client while keeping the reserve free, unknown size (< 0) only
+// checks the reserve. Free space the client can't tell doesn't hold
+// downloads back.
+func HasSpace(c Client, size int64) bool {
+	free, err := c.FreeSpace()
+	if err != nil {
+		log.Printf("free space of %s unknown, %v\n", c.GetSettings().Name, err)
+		return true
+	}
+	if size < 0 {
+		size = 0
+	}
+	return free-size > c.GetSettings().Reserve
+}
+
+// Str2Bytes parses sizes like "100M", "1.4 GB" or "512 KiB"
+func Str2Bytes(str string, def int64) int64 {
+	re := regexp.MustCompile(`^\s*(?P<num>[0-9]+(?:\.[0-9]+)?)\s*(?P<unit>[a-zA-Z]*)\s*$`)
+
+	match := re.FindStringSubmatch(str)
+	if match == nil {
+		return def
+	}
+
+	num, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return def
+	}
+
+	unit := strings.ToUpper(match[2])
+	unit = strings.TrimSuffix(strings.TrimSuffix(unit, "B"), "I")
+
+	switch unit {
+	case "":
+	case "K":
+		num *= 1 << 10
+	case "M":
+		num *= 1 << 20
+	case "G":
+		num *= 1 << 30
+	case "T":
+		num *= 1 << 40
+	default:
+		return def
+	}
+	return int64(num)
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,305 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package download
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	httpTimeout = 30 * time.Second
+
+	transmissionHeader = "X-Transmission-Session-Id"
+)
+
+// Transmission talks to transmission-daemon over its JSON-RPC, URL is
+// the rpc endpoint (http://host:9091/transmission/rpc)
+type Transmission struct {
+	Settings
+	URL      string
+	User     string
+	Password string
+	HTTP     *http.Client
+
+	mu      sync.Mutex
+	session string
+}
+
+var transmissionFields = []string{
+	"hashString", "name", "downloadDir", "sizeWhenDone", "leftUntilDone",
+	"rateDownload", "rateUpload", "peersConnected", "uploadRatio",
+	"addedDate", "status", "metadataPercentComplete", "error", "errorString",
+}
+
+type transmissionTorrent struct {
+	HashString              string  `json:"hashString"`
+	Name                    string  `json:"name"`
+	DownloadDir             string  `json:"downloadDir"`
+	SizeWhenDone            int64   `json:"sizeWhenDone"`
+	LeftUntilDone           int64   `json:"leftUntilDone"`
+	RateDownload            int64   `json:"rateDownload"`
+	RateUpload              int64   `json:"rateUpload"`
+	PeersConnected          int     `json:"peersConnected"`
+	UploadRatio             float64 `json:"uploadRatio"`
+	AddedDate               int64   `json:"addedDate"`
+	Status                  int     `json:"status"`
+	MetadataPercentComplete float64 `json:"metadataPercentComplete"`
+}
+
+type transmissionRequest struct {
+	Method    string      `json:"method"`
+	Arguments interface{} `json:"arguments,omitempty"`
+}
+
+type transmissionResponse struct {
+	Result    string          `json:"result"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// transmission torrent status values
+const (
+	trStopped  = 0
+	trDownload = 4
+	trSeed     = 6
+)
+
+func (tr *Transmission) GetSettings() *Settings {
+	return &tr.Settings
+}
+
+func (tr *Transmission) client() *http.Client {
+	if tr.HTTP != nil {
+		return tr.HTTP
+	}
+	return &http.Client{Timeout: httpTimeout}
+}
+
+func (tr *Transmission) post(body []byte) (*http.Response, error) {
+	req, err := http.NewRequest("POST", tr.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(tr.User) > 0 {
+		req.SetBasicAuth(tr.User, tr.Password)
+	}
+	tr.mu.Lock()
+	req.Header.Set(transmissionHeader, tr.session)
+	tr.mu.Unlock()
+	return tr.client().Do(req)
+}
+
+// rpc calls the method, the session id is (re)negotiated whenever the
+// daemon answers 409 Conflict
+func (tr *Transmission) rpc(method string, args interface{}, result interface{}) error {
+	body, err := json.Marshal(transmissionRequest{Method: method, Arguments: args})
+	if err != nil {
+		return err
+	}
+
+	resp, err := tr.post(body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusConflict {
+		resp.Body.Close()
+		tr.mu.Lock()
+		tr.session = resp.Header.Get(transmissionHeader)
+		tr.mu.Unlock()
+		if resp, err = tr.post(body); err != nil {
+			return err
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("transmission %s failed, %s", method, resp.Status)
+	}
+
+	var r transmissionResponse
+	if err = json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return fmt.Errorf("transmission %s failed, %v", method, err)
+	}
+	if r.Result != "success" {
+		return fmt.Errorf("transmission %s failed, %s", method, r.Result)
+	}
+	if result != nil && len(r.Arguments) > 0 {
+		return json.Unmarshal(r.Arguments, result)
+	}
+	return nil
+}
+
+func (t *transmissionTorrent) status() Status {
+	meta := t.MetadataPercentComplete < 1
+	ratio := t.UploadRatio
+	if ratio < 0 {
+		// -1 not available, -2 infinite
+		ratio = 0
+	}
+	return Status{
+		Hash:     normalizeHash(t.HashString),
+		Name:     t.Name,
+		Path:     filepath.Join(t.DownloadDir, t.Name),
+		Size:     t.SizeWhenDone,
+		Done:     t.SizeWhenDone - t.LeftUntilDone,
+		DownRate: t.RateDownload,
+		UpRate:   t.RateUpload,
+		Peers:    t.PeersConnected,
+		Ratio:    ratio,
+		Added:    t.AddedDate,
+		Started:  t.Status != trStopped,
+		Active:   t.Status == trDownload || t.Status == trSeed,
+		Meta:     meta,
+		Complete: !meta && t.LeftUntilDone == 0,
+	}
+}
+
+func (tr *Transmission) get(ids []string) ([]Status, error) {
+	var (
+		ss   []Status
+		args = map[string]interface{}{"fields": transmissionFields}
+		res  struct {
+			Torrents []transmissionTorrent `json:"torrents"`
+		}
+	)
+
+	if ids != nil {
+		args["ids"] = ids
+	}
+	if err := tr.rpc("torrent-get", args, &res); err != nil {
+		return nil, err
+	}
+	for _, t := range res.Torrents {
+		ss = append(ss, t.status())
+	}
+	return ss, nil
+}
+
+func (tr *Transmission) List() ([]Status, error) {
+	return tr.get(nil)
+}
+
+func (tr *Transmission) Status(hash string) (Status, error) {
+	ss, err := tr.get([]string{strings.ToLower(hash)})
+	if err != nil {
+		return Status{}, err
+	}
+	if len(ss) == 0 {
+		return Status{}, fmt.Errorf("download %s not found", hash)
+	}
+	return ss[0], nil
+}
+
+func (tr *Transmission) Add(magnet string, start bool) (string, error) {
+	var res map[string]transmissionTorrent
+
+	args := map[string]interface{}{
+		"filename": magnet,
+		"paused":   !start,
+	}
+	if err := tr.rpc("torrent-add", args, &res); err != nil {
+		return "", err
+	}
+	// torrent-added or torrent-duplicate
+	for _, t := range res {
+		return normalizeHash(t.HashString), nil
+	}
+	return "", fmt.Errorf("transmission torrent-add failed, no torrent returned")
+}
+
+func (tr *Transmission) ids(hash string) map[string]interface{} {
+	return map[string]interface{}{"ids": []string{strings.ToLower(hash)}}
+}
+
+func (tr *Transmission) Start(hash string) error {
+	return tr.rpc("torrent-start", tr.ids(hash), nil)
+}
+
+func (tr *Transmission) Stop(hash string) error {
+	return tr.rpc("torrent-stop", tr.ids(hash), nil)
+}
+
+// FreeSpace asks the daemon about the download root, its download-dir
+// unless the root is set
+func (tr *Transmission) FreeSpace() (int64, error) {
+	var res struct {
+		DownloadDir string `json:"download-dir"`
+		SizeBytes   int64  `json:"size-bytes"`
+	}
+
+	path := tr.Root
+	if len(path) == 0 {
+		args := map[string]interface{}{"fields": []string{"download-dir"}}
+		if err := tr.rpc("session-get", args, &res); err != nil {
+			return 0, err
+		}
+		path = res.DownloadDir
+	}
+	if err := tr.rpc("free-space", map[string]interface{}{"path": path}, &res); err != nil {
+		return 0, err
+	}
+	return res.SizeBytes, nil
+}
+
+func (tr *Transmission) Trackers(hash string) ([]string, error) {
+	var (
+		urls []string
+		args = tr.ids(hash)
+		res  struct {
+			Torrents []struct {
+				Trackers []struct {
+					Announce string `json:"announce"`
+				} `json:"trackers"`
+			} `json:"torrents"`
+		}
+	)
+
+	args["fields"] = []string{"trackers"}
+	if err := tr.rpc("torrent-get", args, &res); err != nil {
+		return nil, err
+	}
+	if len(res.Torrents) == 0 {
+		return nil, fmt.Errorf("download %s not found", hash)
+	}
+	for _, t := range res.Torrents[0].Trackers {
+		urls = append(urls, t.Announce)
+	}
+	return urls, nil
+}
+
+func (tr *Transmission) remove(hash string, data bool) error {
+	args := tr.ids(hash)
+	args["delete-local-data"] = data
+	return tr.rpc("torrent-remove", args, nil)
+}
+
+// Erase removes the download, the payload stays on the disk
+func (tr *Transmission) Erase(hash string) error {
+	return tr.remove(hash, false)
+}
+
+func (tr *Transmission) EraseWithData(hash string) error {
+	return tr.remove(hash, true)
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,168 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package download
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeTransmission answers the RPC methods tortools uses, the first
+// request of a session is rejected with 409 like the daemon does
+type fakeTransmission struct {
+	session string
+	calls   []transmissionRequest
+	args    []map[string]interface{}
+}
+
+func (f *fakeTransmission) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get(transmissionHeader) != f.session {
+		w.Header().Set(transmissionHeader, f.session)
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	var (
+		call transmissionRequest
+		args map[string]interface{}
+		res  interface{}
+	)
+	call.Arguments = &args
+	if err := json.NewDecoder(req.Body).Decode(&call); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.calls = append(f.calls, call)
+	f.args = append(f.args, args)
+
+	switch call.Method {
+	case "torrent-get":
+		if fields, _ := args["fields"].([]interface{}); len(fields) == 1 {
+			res = map[string]interface{}{"torrents": []interface{}{
+				map[string]interface{}{"trackers": []interface{}{
+					map[string]string{"announce": "http://tracker.example/announce"},
+				}},
+			}}
+			break
+		}
+		res = map[string]interface{}{"torrents": []interface{}{
+			map[string]interface{}{
+				"hashString":              "0123456789abcdef0123456789abcdef01234567",
+				"name":                    "a",
+				"downloadDir":             "/downloads",
+				"sizeWhenDone":            100,
+				"leftUntilDone":           25,
+				"status":                  trDownload,
+				"metadataPercentComplete": 1,
+				"uploadRatio":             -1,
+			},
+		}}
+	case "torrent-add":
+		res = map[string]interface{}{"torrent-added": map[string]string{
+			"hashString": "89abcdef0123456789abcdef0123456789abcdef",
+		}}
+	case "session-get":
+		res = map[string]string{"download-dir": "/downloads"}
+	case "free-space":
+		res = map[string]interface{}{"path": args["path"], "size-bytes": 1024}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"result": "success", "arguments": res})
+}
+
+func newTransmission(t *testing.T) (*Transmission, *fakeTransmission) {
+	f := &fakeTransmission{session: "session"}
+	s := httptest.NewServer(f)
+	t.Cleanup(s.Close)
+	return &Transmission{Settings: Settings{Name: "test"}, URL: s.URL}, f
+}
+
+func TestTransmissionList(t *testing.T) {
+	tr, _ := newTransmission(t)
+
+	ss, err := tr.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ss) != 1 {
+		t.Fatalf("%d downloads listed", len(ss))
+	}
+	s := ss[0]
+	if s.Hash != hashA || s.Path != "/downloads/a" || s.Done != 75 ||
+		!s.Started || !s.Active || s.Meta || s.Complete || s.Ratio != 0 {
+		t.Fatalf("status %+v", s)
+	}
+}
+
+func TestTransmissionAdd(t *testing.T) {
+	tr, f := newTransmission(t)
+
+	hash, err := tr.Add("magnet:?xt=urn:btih:"+hashB, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != hashB {
+		t.Fatalf("hash %s", hash)
+	}
+	if f.args[0]["paused"] != true {
+		t.Fatalf("arguments %v", f.args[0])
+	}
+}
+
+func TestTransmissionErase(t *testing.T) {
+	tr, f := newTransmission(t)
+
+	if err := tr.Erase(hashA); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.EraseWithData(hashA); err != nil {
+		t.Fatal(err)
+	}
+	for i, data := range []bool{false, true} {
+		if f.calls[i].Method != "torrent-remove" ||
+			f.args[i]["delete-local-data"] != data {
+			t.Fatalf("call %s %v", f.calls[i].Method, f.args[i])
+		}
+	}
+}
+
+func TestTransmissionFreeSpace(t *testing.T) {
+	tr, f := newTransmission(t)
+
+	free, err := tr.FreeSpace()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if free != 1024 || f.args[1]["path"] != "/downloads" {
+		t.Fatalf("free %d, arguments %v", free, f.args[1])
+	}
+}
+
+func TestTransmissionTrackers(t *testing.T) {
+	tr, _ := newTransmission(t)
+
+	trackers, err := tr.Trackers(hashA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trackers) != 1 || trackers[0] != "http://tracker.example/announce" {
+		t.Fatalf("trackers %v", trackers)
+	}
+}
+
+/* vim: set ts=2: */
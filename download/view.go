@@ -0,0 +1,117 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package download
+
+import (
+	"fmt"
+	"log"
+)
+
+// Views built into rtorrent, other clients emulate them
+var Views = []string{
+	"main",
+	"default",
+	"name",
+	"active",
+	"started",
+	"stopped",
+	"complete",
+	"incomplete",
+	"hashing",
+	"seeding",
+	"leeching",
+}
+
+func (r *Rtorrent) call(args ...string) error {
+	output, err := r.xmlrpc(args...)
+	if err != nil {
+		return err
+	}
+	value, err := getIntValue(output)
+	if err != nil {
+		return err
+	}
+	if value != 0 {
+		return fmt.Errorf("command %s failed, returned %d", args[0], value)
+	}
+	return nil
+}
+
+// IsBuiltinView reports whether the view is built into rtorrent
+func IsBuiltinView(view string) bool {
+	for _, v := range Views {
+		if v == view {
+			return true
+		}
+	}
+	return false
+}
+
+// HasView reports whether the client has the view, empty is all
+// downloads. Only rtorrent has custom views.
+func HasView(c Client, view string) bool {
+	if len(view) == 0 {
+		return true
+	}
+	r, ok := c.(*Rtorrent)
+	if !ok {
+		return IsBuiltinView(view)
+	}
+	for _, v := range r.GetViews() {
+		if v == view {
+			return true
+		}
+	}
+	return false
+}
+
+// GetViews returns names of built in and custom views
+func (r *Rtorrent) GetViews() []string {
+	output, err := r.xmlrpc("view.list")
+	if err != nil {
+		log.Fatal(err)
+	}
+	return getStrValues(output)
+}
+
+// AddView creates a custom view, filter is an rtorrent command like
+// "d.up.rate=" and only downloads where it is non zero are shown
+func (r *Rtorrent) AddView(name string, filter string) error {
+	if err := r.call("view.add", "", name); err != nil {
+		return err
+	}
+	if len(filter) > 0 {
+		return r.FilterView(name, filter)
+	}
+	return nil
+}
+
+func (r *Rtorrent) FilterView(name string, filter string) error {
+	return r.call("view.filter", "", name, filter)
+}
+
+// SetEventHandler runs command on download event (finished, erased,
+// hash_failed, ...), the handler is identified by name
+func (r *Rtorrent) SetEventHandler(event string, name string, command string) error {
+	return r.call("method.set_key", "", "event.download."+event, name, command)
+}
+
+// Execute runs a program on the rtorrent host
+func (r *Rtorrent) Execute(args ...string) error {
+	return r.call(append([]string{"execute.nothrow", ""}, args...)...)
+}
+
+/* vim: set ts=2: */
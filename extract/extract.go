@@ -0,0 +1,255 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package extract
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/filvarga/tortools/run"
+)
+
+// DefaultExtractor is the external command used for archives the
+// builtin extractor can't handle, {src} and {dst} are substituted
+const DefaultExtractor = "7z x -y -o{dst} {src}"
+
+var (
+	rePart    = regexp.MustCompile(`(?i)\.part0*([0-9]+)\.rar$`)
+	reVolume  = regexp.MustCompile(`(?i)\.(r|z)[0-9]{2}$`)
+	reSplit7z = regexp.MustCompile(`(?i)\.7z\.([0-9]{3})$`)
+)
+
+// 7z exit codes of a bad command line and of running out of memory
+const (
+	exitUsage  = 7
+	exitMemory = 8
+)
+
+// ToolError is a failure of the extractor or of the disk (a missing
+// command, no space left, no permission), unlike other errors it
+// tells nothing about the archive
+type ToolError struct {
+	Err error
+}
+
+func (e *ToolError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ToolError) Unwrap() error {
+	return e.Err
+}
+
+// system turns I/O errors into tool errors, others are kept
+func system(err error) error {
+	var pe *os.PathError
+	if errors.As(err, &pe) {
+		return &ToolError{err}
+	}
+	return err
+}
+
+// output problems the extractor reports along with a fatal error
+var reOutput = regexp.MustCompile(`(?i)no space left|disk full|permission denied|read-only file system|can ?not open output`)
+
+// Find returns the first volume of every archive set among paths,
+// continuation volumes are left out
+func Find(paths []string) []string {
+	var first []string
+
+	for _, path := range paths {
+		name := strings.ToLower(filepath.Base(path))
+		if m := rePart.FindStringSubmatch(name); m != nil {
+			if m[1] == "1" {
+				first = append(first, path)
+			}
+			continue
+		}
+		if m := reSplit7z.FindStringSubmatch(name); m != nil {
+			if m[1] == "001" {
+				first = append(first, path)
+			}
+			continue
+		}
+		if reVolume.MatchString(name) {
+			continue
+		}
+		switch filepath.Ext(name) {
+		case ".rar", ".zip", ".7z":
+			first = append(first, path)
+		}
+	}
+	return first
+}
+
+// Staging is the directory archives of the payload are extracted to,
+// it lives next to the download so the originals keep seeding
+func Staging(path string) string {
+	return filepath.Join(filepath.Dir(path),
+		"."+filepath.Base(path)+".extracted")
+}
+
+// Cleanup removes the staging directory once its content is imported
+func Cleanup(path string) error {
+	return os.RemoveAll(Staging(path))
+}
+
+// split zip archives have .z01 ... volumes next to the .zip
+func isSplitZip(path string) bool {
+	_, err := os.Stat(strings.TrimSuffix(path, filepath.Ext(path)) + ".z01")
+	return err == nil
+}
+
+func unzip(src string, dst string) error {
+	z, err := zip.OpenReader(src)
+	if err != nil {
+		return system(err)
+	}
+	defer z.Close()
+
+	for _, f := range z.File {
+		path := filepath.Join(dst, f.Name)
+		if !strings.HasPrefix(path, filepath.Clean(dst)+string(filepath.Separator)) {
+			return fmt.Errorf("%s escapes %s", f.Name, dst)
+		}
+		if f.FileInfo().IsDir() {
+			if err = os.MkdirAll(path, 0755); err != nil {
+				return system(err)
+			}
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return system(err)
+		}
+		if err = unzipFile(f, path); err != nil {
+			return system(err)
+		}
+	}
+	return nil
+}
+
+func unzipFile(f *zip.File, path string) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func external(extractor string, src string, dst string) error {
+	var args []string
+
+	for _, arg := range strings.Fields(extractor) {
+		arg = strings.ReplaceAll(arg, "{src}", src)
+		arg = strings.ReplaceAll(arg, "{dst}", dst)
+		args = append(args, arg)
+	}
+	if len(args) == 0 {
+		return &ToolError{fmt.Errorf("invalid extractor")}
+	}
+	_, err := run.New(args[0], args[1:]...).Combined().
+		Run(context.Background())
+	if err == nil {
+		return nil
+	}
+	err = fmt.Errorf("%s: %w", filepath.Base(src), err)
+	// not run at all, misused or failed to write the output
+	var ee *run.ExitError
+	if !errors.As(err, &ee) || ee.Code == exitUsage || ee.Code == exitMemory ||
+		reOutput.MatchString(ee.Tail) {
+		return &ToolError{err}
+	}
+	return err
+}
+
+// Extract unpacks the archive set starting at src into dst, plain zip
+// archives are handled in Go unless an extractor command is set
+func Extract(src string, dst string, extractor string) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return system(err)
+	}
+	if len(extractor) == 0 {
+		if strings.EqualFold(filepath.Ext(src), ".zip") && !isSplitZip(src) {
+			return unzip(src, dst)
+		}
+		extractor = DefaultExtractor
+	}
+	return external(extractor, src, dst)
+}
+
+// All extracts every archive set found among paths into dst and
+// returns paths of the extracted files, failures of the extractor or
+// of the disk are *ToolError
+func All(paths []string, dst string, extractor string) ([]string, error) {
+	var files []string
+
+	for _, src := range Find(paths) {
+		if err := Extract(src, dst, extractor); err != nil {
+			return nil, err
+		}
+	}
+	err := filepath.Walk(dst, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			files = append(files, path)
+		}
+		return err
+	})
+	return files, system(err)
+}
+
+// Imported reports whether an importer took the files extracted for
+// the payload at path: moved them away or hard linked them elsewhere.
+// Without a staging directory nothing is imported.
+func Imported(path string) bool {
+	found := false
+	linked := true
+	err := filepath.Walk(Staging(path), func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		found = true
+		if n, ok := nlink(info); !ok || n < 2 {
+			linked = false
+		}
+		return nil
+	})
+	if err != nil {
+		return false
+	}
+	return !found || linked
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,137 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package extract
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	paths := []string{
+		"a/Show.part01.rar", "a/Show.part02.rar", "a/Show.part10.rar",
+		"b/Movie.rar", "b/Movie.r00", "b/Movie.r01",
+		"c/Pack.7z.001", "c/Pack.7z.002",
+		"d/Split.zip", "d/Split.z01",
+		"e/Single.7z", "e/video.mkv", "e/info.nfo",
+		"f/UPPER.PART1.RAR",
+	}
+	want := []string{"a/Show.part01.rar", "b/Movie.rar", "c/Pack.7z.001",
+		"d/Split.zip", "e/Single.7z", "f/UPPER.PART1.RAR"}
+
+	if got := Find(paths); !reflect.DeepEqual(got, want) {
+		t.Fatalf("found %v, expected %v", got, want)
+	}
+}
+
+func writeZip(t *testing.T, path string, files map[string]string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := z.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnzip(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.zip")
+	writeZip(t, src, map[string]string{"sub/video.mkv": "video"})
+
+	dst := filepath.Join(dir, "out")
+	if err := Extract(src, dst, ""); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dst, "sub", "video.mkv"))
+	if err != nil || string(b) != "video" {
+		t.Fatalf("extracted %q, %v", b, err)
+	}
+}
+
+func TestUnzipEscape(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "evil.zip")
+	writeZip(t, src, map[string]string{"../../escaped": "evil"})
+
+	dst := filepath.Join(dir, "deep", "out")
+	if err := Extract(src, dst, ""); err == nil {
+		t.Fatal("archive escaping the destination extracted")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escaped")); !os.IsNotExist(err) {
+		t.Fatal("file written outside of the destination")
+	}
+}
+
+func TestImported(t *testing.T) {
+	dir := t.TempDir()
+	payload := filepath.Join(dir, "Show.S01E01")
+	staging := Staging(payload)
+
+	if Imported(payload) {
+		t.Fatal("payload without staging imported")
+	}
+
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		t.Fatal(err)
+	}
+	video := filepath.Join(staging, "video.mkv")
+	if err := ioutil.WriteFile(video, []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if Imported(payload) {
+		t.Fatal("files left in staging imported")
+	}
+
+	if err := os.Link(video, filepath.Join(dir, "library.mkv")); err != nil {
+		t.Skip(err)
+	}
+	if !Imported(payload) {
+		t.Fatal("hard linked files not imported")
+	}
+
+	if err := os.Remove(video); err != nil {
+		t.Fatal(err)
+	}
+	if !Imported(payload) {
+		t.Fatal("moved files not imported")
+	}
+	if err := Cleanup(payload); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(staging); !os.IsNotExist(err) {
+		t.Fatal("staging not removed")
+	}
+}
+
+/* vim: set ts=2: */
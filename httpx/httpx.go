@@ -0,0 +1,382 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package httpx is the HTTP client search providers share: requests
+// time out, are retried with backoff on 5xx and 429 responses, are rate
+// limited per host and GET responses are cached on the disk, expired
+// entries are removed as new ones are written. Proxies come from
+// HTTP_PROXY, HTTPS_PROXY and ALL_PROXY (socks5:// included).
+package httpx
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultTimeout   = 30 * time.Second
+	DefaultRetries   = 3
+	DefaultBackoff   = time.Second
+	DefaultInterval  = time.Second
+	DefaultTTL       = 15 * time.Minute
+	DefaultUserAgent = "tortools (+https://github.com/filvarga/tortools)"
+
+	// longest wait a Retry-After header is honoured for
+	maxRetryAfter = time.Minute
+)
+
+// StatusError is a response other than 200 OK
+type StatusError struct {
+	URL    string
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("GET %s: %s", e.URL, e.Status)
+}
+
+type Client struct {
+	HTTP      *http.Client
+	UserAgent string
+	// attempts after the first one and the base of the exponential
+	// backoff between them
+	Retries int
+	Backoff time.Duration
+	// Interval is the minimum time between requests to the same host
+	Interval time.Duration
+	// responses are cached in CacheDir for TTL, zero TTL disables it
+	CacheDir string
+	TTL      time.Duration
+
+	mu     sync.Mutex
+	next   map[string]time.Time
+	pruned time.Time
+}
+
+// CacheDir is where responses are cached, $TORTOOLS_CACHE overrides
+// the default user cache directory
+func CacheDir() string {
+	if dir := os.Getenv("TORTOOLS_CACHE"); len(dir) > 0 {
+		return dir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "tortools", "http")
+}
+
+func New() *Client {
+	transport := &http.Transport{
+		Proxy: Proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: DefaultTimeout,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConns:          10,
+	}
+	return &Client{
+		HTTP:      &http.Client{Transport: transport, Timeout: DefaultTimeout},
+		UserAgent: DefaultUserAgent,
+		Retries:   DefaultRetries,
+		Backoff:   DefaultBackoff,
+		Interval:  DefaultInterval,
+		CacheDir:  CacheDir(),
+		TTL:       DefaultTTL,
+	}
+}
+
+func getenv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); len(v) > 0 {
+			return v
+		}
+	}
+	return ""
+}
+
+// bypass reports whether NO_PROXY excludes the host
+func bypass(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" || net.ParseIP(host).IsLoopback() {
+		return true
+	}
+	for _, p := range strings.Split(getenv("NO_PROXY", "no_proxy"), ",") {
+		p = strings.TrimPrefix(strings.TrimSpace(p), "*")
+		if p == "*" {
+			return true
+		}
+		if len(p) > 0 && (host == strings.TrimPrefix(p, ".") ||
+			strings.HasSuffix(host, "."+strings.TrimPrefix(p, "."))) {
+			return true
+		}
+	}
+	return false
+}
+
+// Proxy picks the proxy of the request like http.ProxyFromEnvironment
+// and falls back to ALL_PROXY, socks5:// proxies are supported by the
+// transport itself
+func Proxy(req *http.Request) (*url.URL, error) {
+	u, err := http.ProxyFromEnvironment(req)
+	if u != nil || err != nil {
+		return u, err
+	}
+	all := getenv("ALL_PROXY", "all_proxy")
+	if len(all) == 0 || bypass(req.URL.Host) {
+		return nil, nil
+	}
+	return url.Parse(all)
+}
+
+// wait blocks until the host may be asked again
+func (c *Client) wait(ctx context.Context, host string) error {
+	c.mu.Lock()
+	if c.next == nil {
+		c.next = map[string]time.Time{}
+	}
+	now := time.Now()
+	at := c.next[host]
+	if at.Before(now) {
+		at = now
+	}
+	c.next[host] = at.Add(c.Interval)
+	c.mu.Unlock()
+
+	if d := at.Sub(now); d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func retryable(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// retryAfter is the wait the Retry-After header asks for, in seconds
+// or until an HTTP date, -1 when there is none
+func retryAfter(resp *http.Response, now time.Time) time.Duration {
+	v := resp.Header.Get("Retry-After")
+	if s, err := strconv.Atoi(v); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return -1
+}
+
+// delay is the pause before the attempt (1 based), Retry-After of the
+// last response wins when it is sane
+func (c *Client) delay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if d := retryAfter(resp, time.Now()); d >= 0 && d <= maxRetryAfter {
+			return d
+		}
+	}
+	d := c.Backoff << uint(attempt-1)
+	if d <= 0 {
+		return 0
+	}
+	// jitter keeps concurrent clients apart
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (c *Client) client() *http.Client {
+	if c.HTTP != nil {
+		return c.HTTP
+	}
+	return http.DefaultClient
+}
+
+// Do sends the request, rate limited and retried. Bodies of retried
+// requests must be replayable (see http.Request.GetBody).
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	var (
+		resp *http.Response
+		err  error
+	)
+
+	ctx := req.Context()
+	if len(req.Header.Get("User-Agent")) == 0 && len(c.UserAgent) > 0 {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		if attempt > 0 {
+			if req.Body != nil {
+				if req.GetBody == nil {
+					break
+				}
+				if req.Body, err = req.GetBody(); err != nil {
+					return nil, err
+				}
+			}
+			t := time.NewTimer(c.delay(attempt, resp))
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return nil, ctx.Err()
+			}
+		}
+		if err = c.wait(ctx, req.URL.Host); err != nil {
+			return nil, err
+		}
+
+		resp, err = c.client().Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !retryable(resp.StatusCode) || attempt == c.Retries {
+			return resp, nil
+		}
+		// drained so the connection can be reused
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	if err == nil {
+		err = fmt.Errorf("%s %s: %s", req.Method, req.URL, resp.Status)
+	}
+	return nil, err
+}
+
+func (c *Client) cachePath(u string) string {
+	sum := sha256.Sum256([]byte(u))
+	return filepath.Join(c.CacheDir, hex.EncodeToString(sum[:]))
+}
+
+func (c *Client) cached(u string) ([]byte, bool) {
+	if c.TTL <= 0 || len(c.CacheDir) == 0 {
+		return nil, false
+	}
+	path := c.cachePath(u)
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) > c.TTL {
+		return nil, false
+	}
+	b, err := ioutil.ReadFile(path)
+	return b, err == nil
+}
+
+// prune removes expired entries and leftovers of failed writes, the
+// cache directory is scanned at most once per TTL
+func (c *Client) prune(now time.Time) {
+	c.mu.Lock()
+	if !c.pruned.IsZero() && now.Sub(c.pruned) < c.TTL {
+		c.mu.Unlock()
+		return
+	}
+	c.pruned = now
+	c.mu.Unlock()
+
+	files, err := ioutil.ReadDir(c.CacheDir)
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		if f.Mode().IsRegular() && now.Sub(f.ModTime()) > c.TTL {
+			os.Remove(filepath.Join(c.CacheDir, f.Name()))
+		}
+	}
+}
+
+// cache stores the body, failing to do so only costs a request later
+func (c *Client) cache(u string, b []byte) {
+	if c.TTL <= 0 || len(c.CacheDir) == 0 {
+		return
+	}
+	if err := os.MkdirAll(c.CacheDir, 0755); err != nil {
+		return
+	}
+	c.prune(time.Now())
+
+	f, err := ioutil.TempFile(c.CacheDir, ".tmp.*")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.cachePath(u))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+}
+
+// Get returns the body of a successful (200) GET response, served from
+// the cache while it is fresh
+func (c *Client) Get(ctx context.Context, u string) ([]byte, error) {
+	if b, ok := c.cached(u); ok {
+		return b, nil
+	}
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return b, &StatusError{URL: u, Code: resp.StatusCode, Status: resp.Status}
+	}
+	c.cache(u, b)
+	return b, nil
+}
+
+/* vim: set ts=2: */
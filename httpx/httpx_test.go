@@ -0,0 +1,265 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpx
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// server answers with the codes in turn, the last one repeats
+func server(t *testing.T, codes ...int) (*httptest.Server, *int32) {
+	var n int32
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		req *http.Request) {
+
+		i := int(atomic.AddInt32(&n, 1)) - 1
+		if i >= len(codes) {
+			i = len(codes) - 1
+		}
+		w.WriteHeader(codes[i])
+		w.Write([]byte(req.URL.Path))
+	}))
+	t.Cleanup(s.Close)
+	return s, &n
+}
+
+func testClient() *Client {
+	return &Client{Retries: 3, Backoff: time.Millisecond}
+}
+
+func TestRetry(t *testing.T) {
+	s, n := server(t, 503, 429, 200)
+
+	b, err := testClient().Get(context.Background(), s.URL+"/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "/a" || *n != 3 {
+		t.Fatalf("body %q after %d requests", b, *n)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	s, n := server(t, 500)
+
+	_, err := testClient().Get(context.Background(), s.URL)
+	if e, ok := err.(*StatusError); !ok || e.Code != 500 {
+		t.Fatalf("error %v", err)
+	}
+	if *n != 4 {
+		t.Fatalf("%d requests", *n)
+	}
+}
+
+func TestNoRetry(t *testing.T) {
+	s, n := server(t, 404)
+
+	_, err := testClient().Get(context.Background(), s.URL)
+	if e, ok := err.(*StatusError); !ok || e.Code != 404 {
+		t.Fatalf("error %v", err)
+	}
+	if *n != 1 {
+		t.Fatalf("%d requests", *n)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := &Client{Backoff: 100 * time.Millisecond}
+
+	for attempt, max := range map[int]time.Duration{
+		1: 100 * time.Millisecond,
+		2: 200 * time.Millisecond,
+		3: 400 * time.Millisecond,
+	} {
+		for i := 0; i < 10; i++ {
+			if d := c.delay(attempt, nil); d < max/2 || d > max {
+				t.Fatalf("attempt %d: delay %v", attempt, d)
+			}
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	c := &Client{Backoff: time.Hour}
+	now := time.Now()
+
+	for v, want := range map[string]time.Duration{
+		"2": 2 * time.Second,
+		"0": 0,
+		now.Add(-time.Minute).UTC().Format(http.TimeFormat): 0,
+	} {
+		resp := &http.Response{Header: http.Header{"Retry-After": {v}}}
+		if d := c.delay(1, resp); d != want {
+			t.Errorf("Retry-After %s: delay %v, want %v", v, d, want)
+		}
+	}
+
+	// HTTP dates are rounded to seconds
+	resp := &http.Response{Header: http.Header{"Retry-After": {
+		now.Add(10 * time.Second).UTC().Format(http.TimeFormat)}}}
+	if d := c.delay(1, resp); d < 8*time.Second || d > 10*time.Second {
+		t.Errorf("Retry-After date: delay %v", d)
+	}
+
+	// waits too long or invalid fall back to the backoff
+	for _, v := range []string{"3600", "soon",
+		now.Add(time.Hour).UTC().Format(http.TimeFormat)} {
+
+		resp := &http.Response{Header: http.Header{"Retry-After": {v}}}
+		if d := c.delay(1, resp); d < 30*time.Minute {
+			t.Errorf("Retry-After %s: delay %v", v, d)
+		}
+	}
+}
+
+func TestRetryAfterHonoured(t *testing.T) {
+	var n int32
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		req *http.Request) {
+
+		if atomic.AddInt32(&n, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer s.Close()
+
+	c := &Client{Retries: 1, Backoff: time.Hour}
+	start := time.Now()
+	if _, err := c.Get(context.Background(), s.URL); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < time.Second || d > 10*time.Second {
+		t.Fatalf("retried after %v", d)
+	}
+}
+
+func TestInterval(t *testing.T) {
+	a, _ := server(t, 200)
+	b, _ := server(t, 200)
+
+	c := &Client{Interval: 100 * time.Millisecond}
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get(ctx, a.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Fatalf("3 requests to a host in %v", d)
+	}
+
+	// other hosts don't wait
+	start = time.Now()
+	if _, err := c.Get(ctx, b.URL); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 90*time.Millisecond {
+		t.Fatalf("request to another host waited %v", d)
+	}
+
+	// waiting ends with the context
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.Get(ctx, a.URL); err == nil {
+		t.Fatal("canceled request succeeded")
+	}
+}
+
+func TestCache(t *testing.T) {
+	s, n := server(t, 404, 200)
+
+	c := &Client{CacheDir: t.TempDir(), TTL: time.Minute}
+	ctx := context.Background()
+
+	// errors are not cached
+	if _, err := c.Get(ctx, s.URL+"/a"); err == nil {
+		t.Fatal("404 succeeded")
+	}
+	for i := 0; i < 2; i++ {
+		b, err := c.Get(ctx, s.URL+"/a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "/a" {
+			t.Fatalf("body %q", b)
+		}
+	}
+	if *n != 2 {
+		t.Fatalf("%d requests", *n)
+	}
+
+	// expired entries are fetched again
+	old := time.Now().Add(-2 * time.Minute)
+	if err := os.Chtimes(c.cachePath(s.URL+"/a"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, s.URL+"/a"); err != nil {
+		t.Fatal(err)
+	}
+	if *n != 3 {
+		t.Fatalf("%d requests", *n)
+	}
+}
+
+func TestCachePrune(t *testing.T) {
+	s, _ := server(t, 200)
+
+	dir := t.TempDir()
+	c := &Client{CacheDir: dir, TTL: time.Minute}
+
+	stale := filepath.Join(dir, "stale")
+	if err := ioutil.WriteFile(stale, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+	fresh := filepath.Join(dir, "fresh")
+	if err := ioutil.WriteFile(fresh, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Get(context.Background(), s.URL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatal("expired entry kept")
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(c.cachePath(s.URL)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+/* vim: set ts=2: */
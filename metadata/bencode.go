@@ -0,0 +1,147 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// decode parses one bencoded value of b, returns it and the number of
+// bytes consumed. Strings decode to string, integers to int64, lists
+// to []interface{} and dictionaries to map[string]interface{}.
+func decode(b []byte) (interface{}, int, error) {
+	if len(b) == 0 {
+		return nil, 0, fmt.Errorf("bencode: unexpected end")
+	}
+	switch {
+	case b[0] == 'i':
+		end := bytes.IndexByte(b, 'e')
+		if end < 0 {
+			return nil, 0, fmt.Errorf("bencode: unterminated integer")
+		}
+		i, err := strconv.ParseInt(string(b[1:end]), 10, 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("bencode: invalid integer")
+		}
+		return i, end + 1, nil
+	case b[0] == 'l':
+		var l []interface{}
+		n := 1
+		for n < len(b) && b[n] != 'e' {
+			v, m, err := decode(b[n:])
+			if err != nil {
+				return nil, 0, err
+			}
+			l = append(l, v)
+			n += m
+		}
+		if n >= len(b) {
+			return nil, 0, fmt.Errorf("bencode: unterminated list")
+		}
+		return l, n + 1, nil
+	case b[0] == 'd':
+		d := map[string]interface{}{}
+		n := 1
+		for n < len(b) && b[n] != 'e' {
+			k, m, err := decode(b[n:])
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("bencode: invalid key")
+			}
+			n += m
+			v, m, err := decode(b[n:])
+			if err != nil {
+				return nil, 0, err
+			}
+			d[key] = v
+			n += m
+		}
+		if n >= len(b) {
+			return nil, 0, fmt.Errorf("bencode: unterminated dictionary")
+		}
+		return d, n + 1, nil
+	case b[0] >= '0' && b[0] <= '9':
+		colon := bytes.IndexByte(b, ':')
+		if colon < 0 {
+			return nil, 0, fmt.Errorf("bencode: invalid string")
+		}
+		l, err := strconv.Atoi(string(b[:colon]))
+		if err != nil || l < 0 || colon+1+l > len(b) {
+			return nil, 0, fmt.Errorf("bencode: invalid string length")
+		}
+		return string(b[colon+1 : colon+1+l]), colon + 1 + l, nil
+	}
+	return nil, 0, fmt.Errorf("bencode: invalid value %q", b[0])
+}
+
+// encode bencodes strings, integers, lists and dictionaries, keys of
+// dictionaries are sorted as the format requires
+func encode(v interface{}) []byte {
+	var buf bytes.Buffer
+
+	switch v := v.(type) {
+	case string:
+		fmt.Fprintf(&buf, "%d:%s", len(v), v)
+	case []byte:
+		fmt.Fprintf(&buf, "%d:%s", len(v), v)
+	case int:
+		fmt.Fprintf(&buf, "i%de", v)
+	case int64:
+		fmt.Fprintf(&buf, "i%de", v)
+	case []interface{}:
+		buf.WriteByte('l')
+		for _, e := range v {
+			buf.Write(encode(e))
+		}
+		buf.WriteByte('e')
+	case map[string]interface{}:
+		var keys []string
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('d')
+		for _, k := range keys {
+			buf.Write(encode(k))
+			buf.Write(encode(v[k]))
+		}
+		buf.WriteByte('e')
+	}
+	return buf.Bytes()
+}
+
+func getInt(d map[string]interface{}, key string) (int64, bool) {
+	i, ok := d[key].(int64)
+	return i, ok
+}
+
+func getStr(d map[string]interface{}, key string) (string, bool) {
+	s, ok := d[key].(string)
+	return s, ok
+}
+
+func getDict(d map[string]interface{}, key string) (map[string]interface{}, bool) {
+	m, ok := d[key].(map[string]interface{})
+	return m, ok
+}
+
+/* vim: set ts=2: */
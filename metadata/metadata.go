@@ -0,0 +1,243 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package metadata resolves the info dictionary of a magnet link from
+// the peers of its trackers over the extension protocol (BEP 9/10), so
+// the real file list and size are known before the magnet is added.
+package metadata
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/filvarga/tortools/download"
+)
+
+const (
+	DefaultTimeout = 30 * time.Second
+	DefaultPeers   = 8
+)
+
+type File struct {
+	Path string
+	Size int64
+}
+
+// Info is the content of a torrent as described by its info dictionary
+type Info struct {
+	Hash        string
+	Name        string
+	Size        int64
+	PieceLength int64
+	Files       []File
+}
+
+// Paths returns file paths prefixed by the torrent name, the way they
+// end up on the disk
+func (i *Info) Paths() []string {
+	var paths []string
+	for _, f := range i.Files {
+		paths = append(paths, path.Join(i.Name, f.Path))
+	}
+	return paths
+}
+
+// Fetcher resolves metadata asking Peers peers at a time, Trackers are
+// asked in addition to the ones of the magnet
+type Fetcher struct {
+	Timeout  time.Duration
+	Peers    int
+	Trackers []string
+}
+
+// Fetch resolves the metadata using the default fetcher
+func Fetch(magnet string, timeout time.Duration) (*Info, error) {
+	f := Fetcher{Timeout: timeout}
+	return f.Fetch(context.Background(), magnet)
+}
+
+func (f *Fetcher) Fetch(ctx context.Context, magnet string) (*Info, error) {
+	var hash [20]byte
+
+	h := download.MagnetHash(magnet)
+	if len(h) == 0 {
+		return nil, fmt.Errorf("magnet %s has no info-hash", magnet)
+	}
+	b, _ := hex.DecodeString(h)
+	copy(hash[:], b)
+
+	u, err := url.Parse(magnet)
+	if err != nil {
+		return nil, err
+	}
+	trackers := append(u.Query()["tr"], f.Trackers...)
+	// x.pe are peers given by the magnet itself
+	peers := u.Query()["x.pe"]
+
+	timeout := f.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	peerID := newPeerID()
+	for _, tracker := range trackers {
+		addrs, err := announce(ctx, tracker, hash, peerID)
+		if err != nil {
+			continue
+		}
+		peers = append(peers, addrs...)
+	}
+	if len(peers) == 0 {
+		return nil, fmt.Errorf("no peers found for %s", h)
+	}
+	return f.fetchPeers(ctx, hash, peerID, unique(peers))
+}
+
+// fetchPeers asks the peers concurrently, the first valid info
+// dictionary wins
+func (f *Fetcher) fetchPeers(ctx context.Context, hash [20]byte,
+	peerID [20]byte, peers []string) (*Info, error) {
+
+	type result struct {
+		raw []byte
+		err error
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := f.Peers
+	if workers <= 0 {
+		workers = DefaultPeers
+	}
+	jobs := make(chan string)
+	results := make(chan result)
+	for i := 0; i < workers && i < len(peers); i++ {
+		go func() {
+			for addr := range jobs {
+				raw, err := fetchPeer(ctx, addr, hash, peerID)
+				select {
+				case results <- result{raw, err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for _, addr := range peers {
+			select {
+			case jobs <- addr:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	last := fmt.Errorf("no peer has metadata of %X", hash)
+	for range peers {
+		select {
+		case r := <-results:
+			if r.err != nil {
+				last = r.err
+				continue
+			}
+			return Parse(r.raw)
+		case <-ctx.Done():
+			return nil, fmt.Errorf("metadata of %X: %v", hash, ctx.Err())
+		}
+	}
+	return nil, last
+}
+
+// Parse decodes the info dictionary of a torrent
+func Parse(raw []byte) (*Info, error) {
+	v, _, err := decode(raw)
+	if err != nil {
+		return nil, err
+	}
+	d, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("info is not a dictionary")
+	}
+
+	info := &Info{}
+	info.Hash = fmt.Sprintf("%X", sha1.Sum(raw))
+	info.Name, _ = getStr(d, "name")
+	info.PieceLength, _ = getInt(d, "piece length")
+
+	if length, ok := getInt(d, "length"); ok {
+		// single file torrent
+		info.Files = []File{{Path: "", Size: length}}
+		info.Size = length
+		return info, nil
+	}
+
+	files, ok := d["files"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("info has neither length nor files")
+	}
+	for _, f := range files {
+		fd, ok := f.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid file entry")
+		}
+		var parts []string
+		l, _ := fd["path"].([]interface{})
+		for _, p := range l {
+			if s, ok := p.(string); ok {
+				parts = append(parts, s)
+			}
+		}
+		length, _ := getInt(fd, "length")
+		info.Files = append(info.Files, File{
+			Path: strings.Join(parts, "/"),
+			Size: length,
+		})
+		info.Size += length
+	}
+	return info, nil
+}
+
+func newPeerID() [20]byte {
+	var id [20]byte
+	copy(id[:], "-TT0001-")
+	rand.Read(id[8:])
+	return id
+}
+
+func unique(values []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,297 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testInfo is an info dictionary spanning two metadata pieces
+func testInfo() []byte {
+	return encode(map[string]interface{}{
+		"name":         "Show.S01E01.1080p",
+		"piece length": 262144,
+		"pieces":       strings.Repeat("x", 20*1000),
+		"files": []interface{}{
+			map[string]interface{}{"length": 1000, "path": []interface{}{"show.mkv"}},
+			map[string]interface{}{"length": 20, "path": []interface{}{"sub", "show.srt"}},
+		},
+	})
+}
+
+// peer serves the metadata over the extension protocol the way
+// BitTorrent clients do, the info is corrupted or requests rejected
+// on demand
+type peer struct {
+	info    []byte
+	corrupt bool
+	reject  bool
+	l       net.Listener
+}
+
+func newPeer(t *testing.T, info []byte) *peer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &peer{info: info, l: l}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn)
+		}
+	}()
+	return p
+}
+
+func (p *peer) addr() string {
+	return p.l.Addr().String()
+}
+
+func (p *peer) serve(conn net.Conn) {
+	var remoteID int64
+
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req := make([]byte, 68)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return
+	}
+	res := append([]byte{}, req[:48]...)
+	res = append(res, []byte("-XX0001-000000000000")...)
+	if _, err := conn.Write(res); err != nil {
+		return
+	}
+
+	for {
+		msg, err := readMessage(conn)
+		if err != nil || len(msg) < 2 || msg[0] != msgExtended {
+			return
+		}
+		v, _, err := decode(msg[2:])
+		if err != nil {
+			return
+		}
+		dict, _ := v.(map[string]interface{})
+		switch msg[1] {
+		case extHandshake:
+			m, _ := getDict(dict, "m")
+			remoteID, _ = getInt(m, "ut_metadata")
+			writeExtended(conn, extHandshake, encode(map[string]interface{}{
+				"m":             map[string]interface{}{"ut_metadata": 3},
+				"metadata_size": len(p.info),
+			}))
+		case 3:
+			piece, _ := getInt(dict, "piece")
+			if p.reject {
+				writeExtended(conn, byte(remoteID), encode(map[string]interface{}{
+					"msg_type": metaReject, "piece": piece,
+				}))
+				continue
+			}
+			end := (piece + 1) * metaPieceSize
+			if end > int64(len(p.info)) {
+				end = int64(len(p.info))
+			}
+			data := append([]byte{}, p.info[piece*metaPieceSize:end]...)
+			if p.corrupt {
+				data[0] ^= 0xff
+			}
+			header := encode(map[string]interface{}{
+				"msg_type": metaData, "piece": piece, "total_size": len(p.info),
+			})
+			writeExtended(conn, byte(remoteID), append(header, data...))
+		}
+	}
+}
+
+func magnet(info []byte, params string) string {
+	return fmt.Sprintf("magnet:?xt=urn:btih:%X%s", sha1.Sum(info), params)
+}
+
+func TestFetchPeer(t *testing.T) {
+	info := testInfo()
+	p := newPeer(t, info)
+
+	got, err := Fetch(magnet(info, "&x.pe="+p.addr()), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Hash != fmt.Sprintf("%X", sha1.Sum(info)) || got.Name != "Show.S01E01.1080p" ||
+		got.Size != 1020 || got.PieceLength != 262144 {
+		t.Fatalf("info %+v", got)
+	}
+	paths := got.Paths()
+	if len(paths) != 2 || paths[0] != "Show.S01E01.1080p/show.mkv" ||
+		paths[1] != "Show.S01E01.1080p/sub/show.srt" {
+		t.Fatalf("paths %v", paths)
+	}
+}
+
+func TestFetchTracker(t *testing.T) {
+	info := testInfo()
+	p := newPeer(t, info)
+
+	// HTTP tracker answering with the compact peer list
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		req *http.Request) {
+
+		hash := sha1.Sum(info)
+		// a seeder may get no seeders
+		if req.URL.Query().Get("left") == "0" {
+			w.Write(encode(map[string]interface{}{"interval": 1800, "peers": ""}))
+			return
+		}
+		if req.URL.Query().Get("info_hash") != string(hash[:]) {
+			w.Write(encode(map[string]interface{}{"failure reason": "unknown torrent"}))
+			return
+		}
+		host, port, _ := net.SplitHostPort(p.addr())
+		n, _ := strconv.Atoi(port)
+		compact := make([]byte, 6)
+		copy(compact, net.ParseIP(host).To4())
+		binary.BigEndian.PutUint16(compact[4:], uint16(n))
+		w.Write(encode(map[string]interface{}{"interval": 1800, "peers": compact}))
+	}))
+	defer tracker.Close()
+
+	got, err := Fetch(magnet(info, "&tr="+url.QueryEscape(tracker.URL+"/announce")),
+		5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Size != 1020 {
+		t.Fatalf("info %+v", got)
+	}
+}
+
+func TestFetchMismatch(t *testing.T) {
+	info := testInfo()
+	p := newPeer(t, info)
+	p.corrupt = true
+
+	_, err := Fetch(magnet(info, "&x.pe="+p.addr()), 5*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("corrupt metadata accepted, %v", err)
+	}
+}
+
+func TestFetchRejected(t *testing.T) {
+	info := testInfo()
+	p := newPeer(t, info)
+	p.reject = true
+
+	_, err := Fetch(magnet(info, "&x.pe="+p.addr()), 5*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Fatalf("rejected request succeeded, %v", err)
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	info := testInfo()
+
+	// a peer accepting connections without ever answering
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				break
+			}
+			conns = append(conns, conn)
+		}
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	f := Fetcher{Timeout: 200 * time.Millisecond}
+	start := time.Now()
+	_, err = f.Fetch(context.Background(), magnet(info, "&x.pe="+l.Addr().String()))
+	if err == nil || time.Since(start) > 2*time.Second {
+		t.Fatalf("fetch took %v, %v", time.Since(start), err)
+	}
+}
+
+func TestParseTorrent(t *testing.T) {
+	raw := testInfo()
+	v, _, err := decode(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := encode(map[string]interface{}{
+		"announce": "http://a.example/announce",
+		"announce-list": []interface{}{
+			[]interface{}{"http://a.example/announce"},
+			[]interface{}{"udp://b.example:1337/announce"},
+		},
+		"info": v,
+	})
+
+	info, trackers, err := ParseTorrent(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Hash != fmt.Sprintf("%X", sha1.Sum(raw)) || info.Size != 1020 {
+		t.Fatalf("info %+v", info)
+	}
+	if fmt.Sprint(trackers) != "[http://a.example/announce udp://b.example:1337/announce]" {
+		t.Fatalf("trackers %v", trackers)
+	}
+
+	if _, _, err = ParseTorrent([]byte("d8:announce1:xe")); err == nil {
+		t.Fatal("torrent without info parsed")
+	}
+}
+
+func TestParseSingleFile(t *testing.T) {
+	info, err := Parse(encode(map[string]interface{}{
+		"name": "movie.mkv", "length": 4096, "piece length": 1024,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 4096 || len(info.Files) != 1 {
+		t.Fatalf("info %+v", info)
+	}
+	if info.Paths()[0] != "movie.mkv" {
+		t.Fatalf("paths %v", info.Paths())
+	}
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,203 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+const (
+	protocol = "BitTorrent protocol"
+
+	// extension protocol (BEP 10), ut_metadata (BEP 9)
+	msgExtended   = 20
+	extHandshake  = 0
+	utMetadataID  = 1
+	metaRequest   = 0
+	metaData      = 1
+	metaReject    = 2
+	metaPieceSize = 16 * 1024
+
+	// limits guarding against hostile peers
+	maxMessage  = metaPieceSize + 1024*1024
+	maxMetadata = 16 * 1024 * 1024
+)
+
+func handshake(conn net.Conn, hash [20]byte, peerID [20]byte) error {
+	var b bytes.Buffer
+
+	b.WriteByte(byte(len(protocol)))
+	b.WriteString(protocol)
+	reserved := make([]byte, 8)
+	reserved[5] |= 0x10 // extension protocol
+	b.Write(reserved)
+	b.Write(hash[:])
+	b.Write(peerID[:])
+	if _, err := conn.Write(b.Bytes()); err != nil {
+		return err
+	}
+
+	res := make([]byte, 68)
+	if _, err := io.ReadFull(conn, res); err != nil {
+		return err
+	}
+	if res[0] != byte(len(protocol)) || string(res[1:20]) != protocol {
+		return fmt.Errorf("peer %s: invalid handshake", conn.RemoteAddr())
+	}
+	if res[25]&0x10 == 0 {
+		return fmt.Errorf("peer %s: no extension protocol", conn.RemoteAddr())
+	}
+	if !bytes.Equal(res[28:48], hash[:]) {
+		return fmt.Errorf("peer %s: info-hash mismatch", conn.RemoteAddr())
+	}
+	return nil
+}
+
+func writeExtended(conn net.Conn, id byte, payload []byte) error {
+	msg := make([]byte, 6, 6+len(payload))
+	binary.BigEndian.PutUint32(msg, uint32(2+len(payload)))
+	msg[4] = msgExtended
+	msg[5] = id
+	_, err := conn.Write(append(msg, payload...))
+	return err
+}
+
+// readMessage returns the next message, nil for keep-alives
+func readMessage(conn net.Conn) ([]byte, error) {
+	var length uint32
+
+	if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if length > maxMessage {
+		return nil, fmt.Errorf("peer %s: message too long", conn.RemoteAddr())
+	}
+	msg := make([]byte, length)
+	_, err := io.ReadFull(conn, msg)
+	return msg, err
+}
+
+// fetchPeer downloads the info dictionary from a single peer, the
+// result is checked against the info-hash
+func fetchPeer(ctx context.Context, addr string, hash [20]byte,
+	peerID [20]byte) ([]byte, error) {
+
+	var (
+		d      net.Dialer
+		id     int64
+		pieces [][]byte
+		size   int64
+		got    int
+	)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// unblock reads once the context is done
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	if err = handshake(conn, hash, peerID); err != nil {
+		return nil, err
+	}
+	err = writeExtended(conn, extHandshake, encode(map[string]interface{}{
+		"m": map[string]interface{}{"ut_metadata": utMetadataID},
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		msg, err := readMessage(conn)
+		if err != nil {
+			return nil, err
+		}
+		if len(msg) < 2 || msg[0] != msgExtended {
+			continue
+		}
+
+		v, n, err := decode(msg[2:])
+		if err != nil {
+			return nil, fmt.Errorf("peer %s: %v", addr, err)
+		}
+		dict, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("peer %s: invalid message", addr)
+		}
+
+		switch msg[1] {
+		case extHandshake:
+			m, _ := getDict(dict, "m")
+			id, _ = getInt(m, "ut_metadata")
+			size, _ = getInt(dict, "metadata_size")
+			if id <= 0 || id > 255 {
+				return nil, fmt.Errorf("peer %s: no ut_metadata", addr)
+			}
+			if size <= 0 || size > maxMetadata {
+				return nil, fmt.Errorf("peer %s: invalid metadata size %d", addr, size)
+			}
+			pieces = make([][]byte, (size+metaPieceSize-1)/metaPieceSize)
+			for i := range pieces {
+				err = writeExtended(conn, byte(id), encode(map[string]interface{}{
+					"msg_type": metaRequest,
+					"piece":    i,
+				}))
+				if err != nil {
+					return nil, err
+				}
+			}
+		case utMetadataID:
+			typ, _ := getInt(dict, "msg_type")
+			piece, _ := getInt(dict, "piece")
+			if typ == metaReject {
+				return nil, fmt.Errorf("peer %s: metadata request rejected", addr)
+			}
+			if typ != metaData || piece < 0 || piece >= int64(len(pieces)) {
+				continue
+			}
+			if pieces[piece] == nil {
+				got++
+			}
+			pieces[piece] = msg[2+n:]
+			if got < len(pieces) {
+				continue
+			}
+			info := bytes.Join(pieces, nil)
+			if int64(len(info)) != size || sha1.Sum(info) != hash {
+				return nil, fmt.Errorf("peer %s: metadata does not match info-hash", addr)
+			}
+			return info, nil
+		}
+	}
+}
+
+/* vim: set ts=2: */
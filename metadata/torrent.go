@@ -0,0 +1,88 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"fmt"
+)
+
+// rawInfo returns the info dictionary of a .torrent file as it is
+// encoded there, the info-hash is computed over exactly these bytes
+func rawInfo(b []byte) ([]byte, map[string]interface{}, error) {
+	var raw []byte
+
+	if len(b) == 0 || b[0] != 'd' {
+		return nil, nil, fmt.Errorf("torrent is not a dictionary")
+	}
+	d := map[string]interface{}{}
+	n := 1
+	for n < len(b) && b[n] != 'e' {
+		k, m, err := decode(b[n:])
+		if err != nil {
+			return nil, nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, nil, fmt.Errorf("bencode: invalid key")
+		}
+		n += m
+		v, m, err := decode(b[n:])
+		if err != nil {
+			return nil, nil, err
+		}
+		if key == "info" {
+			raw = b[n : n+m]
+		}
+		d[key] = v
+		n += m
+	}
+	if raw == nil {
+		return nil, nil, fmt.Errorf("torrent has no info dictionary")
+	}
+	return raw, d, nil
+}
+
+// ParseTorrent decodes a .torrent file, returns its content and the
+// trackers it announces to
+func ParseTorrent(b []byte) (*Info, []string, error) {
+	var trackers []string
+
+	raw, d, err := rawInfo(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := Parse(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if announce, ok := getStr(d, "announce"); ok {
+		trackers = append(trackers, announce)
+	}
+	// announce-list is a list of tiers, each a list of URLs
+	tiers, _ := d["announce-list"].([]interface{})
+	for _, tier := range tiers {
+		urls, _ := tier.([]interface{})
+		for _, u := range urls {
+			if s, ok := u.(string); ok {
+				trackers = append(trackers, s)
+			}
+		}
+	}
+	return info, unique(trackers), nil
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,218 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// port announced to trackers, tortools never accepts connections
+const announcePort = 6881
+
+// bytes left announced, the size is unknown until the metadata is and
+// trackers may leave seeders out of the peers of a seeder
+const announceLeft = 1 << 20
+
+// udp tracker protocol (BEP 15)
+const (
+	udpMagic    = 0x41727101980
+	udpConnect  = 0
+	udpAnnounce = 1
+	udpError    = 3
+)
+
+// announce asks the tracker for peers of the torrent
+func announce(ctx context.Context, tracker string, hash [20]byte,
+	peerID [20]byte) ([]string, error) {
+
+	u, err := url.Parse(tracker)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return announceHTTP(ctx, u, hash, peerID)
+	case "udp":
+		return announceUDP(ctx, u.Host, hash, peerID)
+	}
+	return nil, fmt.Errorf("tracker %s: unsupported scheme", tracker)
+}
+
+func announceHTTP(ctx context.Context, u *url.URL, hash [20]byte,
+	peerID [20]byte) ([]string, error) {
+
+	q := u.Query()
+	q.Set("info_hash", string(hash[:]))
+	q.Set("peer_id", string(peerID[:]))
+	q.Set("port", strconv.Itoa(announcePort))
+	q.Set("uploaded", "0")
+	q.Set("downloaded", "0")
+	q.Set("left", strconv.Itoa(announceLeft))
+	q.Set("compact", "1")
+	q.Set("numwant", "50")
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tracker %s: %s", u.Host, resp.Status)
+	}
+
+	v, _, err := decode(body)
+	if err != nil {
+		return nil, fmt.Errorf("tracker %s: %v", u.Host, err)
+	}
+	d, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("tracker %s: invalid response", u.Host)
+	}
+	if reason, ok := getStr(d, "failure reason"); ok {
+		return nil, fmt.Errorf("tracker %s: %s", u.Host, reason)
+	}
+
+	switch peers := d["peers"].(type) {
+	case string:
+		return compactPeers([]byte(peers)), nil
+	case []interface{}:
+		var addrs []string
+		for _, p := range peers {
+			if p, ok := p.(map[string]interface{}); ok {
+				ip, _ := getStr(p, "ip")
+				port, _ := getInt(p, "port")
+				addrs = append(addrs, net.JoinHostPort(ip, strconv.FormatInt(port, 10)))
+			}
+		}
+		return addrs, nil
+	}
+	return nil, nil
+}
+
+// compactPeers decodes 6 byte (ipv4 + port) peer entries
+func compactPeers(b []byte) []string {
+	var addrs []string
+	for i := 0; i+6 <= len(b); i += 6 {
+		ip := net.IP(b[i : i+4])
+		port := binary.BigEndian.Uint16(b[i+4 : i+6])
+		addrs = append(addrs, net.JoinHostPort(ip.String(), strconv.Itoa(int(port))))
+	}
+	return addrs
+}
+
+func udpRoundTrip(conn net.Conn, req []byte, action uint32, tid uint32) ([]byte, error) {
+	buf := make([]byte, 2048)
+
+	// lost datagrams are retried a couple of times
+	for i := 0; i < 3; i++ {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if n < 8 || binary.BigEndian.Uint32(buf[4:8]) != tid {
+			continue
+		}
+		switch binary.BigEndian.Uint32(buf[0:4]) {
+		case action:
+			return buf[8:n], nil
+		case udpError:
+			return nil, fmt.Errorf("tracker %s: %s", conn.RemoteAddr(), buf[8:n])
+		}
+	}
+	return nil, fmt.Errorf("tracker %s: no response", conn.RemoteAddr())
+}
+
+func announceUDP(ctx context.Context, host string, hash [20]byte,
+	peerID [20]byte) ([]string, error) {
+
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "udp", host)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	tid := rand.Uint32()
+	req := make([]byte, 16)
+	binary.BigEndian.PutUint64(req[0:], udpMagic)
+	binary.BigEndian.PutUint32(req[8:], udpConnect)
+	binary.BigEndian.PutUint32(req[12:], tid)
+	res, err := udpRoundTrip(conn, req, udpConnect, tid)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) < 8 {
+		return nil, fmt.Errorf("tracker %s: invalid response", host)
+	}
+	cid := binary.BigEndian.Uint64(res)
+
+	tid = rand.Uint32()
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, cid)
+	binary.Write(&b, binary.BigEndian, uint32(udpAnnounce))
+	binary.Write(&b, binary.BigEndian, tid)
+	b.Write(hash[:])
+	b.Write(peerID[:])
+	binary.Write(&b, binary.BigEndian, int64(0))            // downloaded
+	binary.Write(&b, binary.BigEndian, int64(announceLeft)) // left
+	binary.Write(&b, binary.BigEndian, int64(0))            // uploaded
+	binary.Write(&b, binary.BigEndian, uint32(0))           // event
+	binary.Write(&b, binary.BigEndian, uint32(0))           // ip
+	binary.Write(&b, binary.BigEndian, rand.Uint32())
+	binary.Write(&b, binary.BigEndian, int32(50)) // numwant
+	binary.Write(&b, binary.BigEndian, uint16(announcePort))
+	res, err = udpRoundTrip(conn, b.Bytes(), udpAnnounce, tid)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) < 12 {
+		return nil, fmt.Errorf("tracker %s: invalid response", host)
+	}
+	// interval, leechers and seeders precede the peers
+	return compactPeers(res[12:]), nil
+}
+
+/* vim: set ts=2: */
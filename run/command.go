@@ -0,0 +1,262 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package run
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bytes of stderr kept by ExitError
+const tailSize = 1024
+
+// Command builds an external command, zero value options run the
+// command in the current directory and environment without a timeout
+type Command struct {
+	name     string
+	args     []string
+	dir      string
+	env      []string
+	timeout  time.Duration
+	combined bool
+	onLine   func(line string)
+	stdout   io.Writer
+	stderr   io.Writer
+}
+
+// Result holds captured output, with Combined both streams end up
+// in Stdout
+type Result struct {
+	Stdout []byte
+	Stderr []byte
+}
+
+// ExitError is returned for commands that ran but exited non zero
+type ExitError struct {
+	Name string
+	Code int
+	Tail string
+}
+
+func (e *ExitError) Error() string {
+	if len(e.Tail) == 0 {
+		return fmt.Sprintf("command %s failed, exit status %d is non zero",
+			e.Name, e.Code)
+	}
+	return fmt.Sprintf("command %s failed, exit status %d is non zero: %s",
+		e.Name, e.Code, e.Tail)
+}
+
+func New(name string, args ...string) *Command {
+	return &Command{name: name, args: args}
+}
+
+func (c *Command) Dir(dir string) *Command {
+	c.dir = dir
+	return c
+}
+
+// Env adds KEY=VALUE variables to the inherited environment
+func (c *Command) Env(env ...string) *Command {
+	c.env = append(c.env, env...)
+	return c
+}
+
+func (c *Command) Timeout(timeout time.Duration) *Command {
+	c.timeout = timeout
+	return c
+}
+
+// Combined captures stdout and stderr interleaved into one buffer
+func (c *Command) Combined() *Command {
+	c.combined = true
+	return c
+}
+
+// OnLine is called for every output line as soon as it is written
+func (c *Command) OnLine(fn func(line string)) *Command {
+	c.onLine = fn
+	return c
+}
+
+// Tee copies output to the writers in addition to capturing it
+func (c *Command) Tee(stdout io.Writer, stderr io.Writer) *Command {
+	c.stdout = stdout
+	c.stderr = stderr
+	return c
+}
+
+// lineWriter splits written data into lines for the callback, writes
+// from stdout and stderr goroutines are serialized
+type lineWriter struct {
+	mu  *sync.Mutex
+	fn  func(line string)
+	buf []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.fn(strings.TrimSuffix(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) flush() {
+	if len(w.buf) > 0 {
+		w.fn(string(w.buf))
+		w.buf = nil
+	}
+}
+
+func tail(b []byte) string {
+	if len(b) > tailSize {
+		b = b[len(b)-tailSize:]
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func (c *Command) String() string {
+	return strings.Join(append([]string{c.name}, c.args...), " ")
+}
+
+// Argv returns the command name followed by its arguments
+func (c *Command) Argv() []string {
+	return append([]string{c.name}, c.args...)
+}
+
+// Run executes the command by Default runner
+func (c *Command) Run(ctx context.Context) (Result, error) {
+	return Default.Run(ctx, c)
+}
+
+// exec runs the command until it exits, ctx is cancelled or the
+// timeout expires
+func (c *Command) exec(ctx context.Context) (Result, error) {
+	var (
+		stdout  bytes.Buffer
+		stderr  bytes.Buffer
+		writers []*lineWriter
+	)
+
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, c.name, c.args...)
+	cmd.Dir = c.dir
+	if len(c.env) > 0 {
+		cmd.Env = append(os.Environ(), c.env...)
+	}
+
+	mu := &sync.Mutex{}
+	build := func(buf *bytes.Buffer, tee io.Writer) io.Writer {
+		ws := []io.Writer{buf}
+		if tee != nil {
+			ws = append(ws, tee)
+		}
+		if c.onLine != nil {
+			lw := &lineWriter{mu: mu, fn: c.onLine}
+			writers = append(writers, lw)
+			ws = append(ws, lw)
+		}
+		if len(ws) == 1 {
+			return buf
+		}
+		return io.MultiWriter(ws...)
+	}
+
+	if c.combined {
+		// the very same writer, exec serializes writes to it
+		w := build(&stdout, c.stdout)
+		cmd.Stdout = w
+		cmd.Stderr = w
+	} else {
+		cmd.Stdout = build(&stdout, c.stdout)
+		cmd.Stderr = build(&stderr, c.stderr)
+	}
+
+	err := cmd.Run()
+	for _, lw := range writers {
+		lw.flush()
+	}
+
+	res := Result{Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return res, fmt.Errorf("command %s timed out", c.name)
+	} else if ctx.Err() != nil {
+		return res, ctx.Err()
+	}
+
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		out := res.Stderr
+		if c.combined {
+			out = res.Stdout
+		}
+		return res, &ExitError{Name: c.name, Code: ee.ExitCode(), Tail: tail(out)}
+	}
+	return res, err
+}
+
+// Output runs the command and returns its stdout (or combined output)
+func (c *Command) Output(ctx context.Context) ([]byte, error) {
+	res, err := c.Run(ctx)
+	return res.Stdout, err
+}
+
+// output feeds output produced by a runner other than exec to the tee
+// writers and the line callback
+func (c *Command) output(stdout []byte, stderr []byte) Result {
+	if c.stdout != nil {
+		c.stdout.Write(stdout)
+	}
+	if c.stderr != nil {
+		c.stderr.Write(stderr)
+	}
+	if c.onLine != nil {
+		for _, b := range [][]byte{stdout, stderr} {
+			lw := &lineWriter{mu: &sync.Mutex{}, fn: c.onLine}
+			lw.Write(b)
+			lw.flush()
+		}
+	}
+	if c.combined {
+		return Result{Stdout: append(append([]byte{}, stdout...), stderr...)}
+	}
+	return Result{Stdout: stdout, Stderr: stderr}
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,148 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package run
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sh(script string) *Command {
+	return New("sh", "-c", script)
+}
+
+func TestOutput(t *testing.T) {
+	dir := t.TempDir()
+	res, err := sh(`pwd; echo "$TORTOOLS_TEST"; echo err >&2`).Dir(dir).
+		Env("TORTOOLS_TEST=value").Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(res.Stdout)), "\n")
+	if len(lines) != 2 || lines[1] != "value" {
+		t.Fatalf("stdout %q", res.Stdout)
+	}
+	// the temporary directory may be reached through a symlink
+	if want, _ := filepath.EvalSymlinks(dir); lines[0] != dir && lines[0] != want {
+		t.Fatalf("ran in %s, expected %s", lines[0], dir)
+	}
+	if string(res.Stderr) != "err\n" {
+		t.Fatalf("stderr %q", res.Stderr)
+	}
+}
+
+func TestExitError(t *testing.T) {
+	res, err := sh(`echo out; echo err >&2; exit 3`).Run(context.Background())
+	var ee *ExitError
+	if !errors.As(err, &ee) {
+		t.Fatalf("error %v", err)
+	}
+	if ee.Name != "sh" || ee.Code != 3 || ee.Tail != "err" {
+		t.Fatalf("exit error %+v", ee)
+	}
+	if string(res.Stdout) != "out\n" {
+		t.Fatalf("stdout %q", res.Stdout)
+	}
+
+	// only the end of long output is kept
+	_, err = sh(`i=0; while [ $i -lt 500 ]; do echo line $i >&2; i=$((i+1)); done; exit 1`).
+		Run(context.Background())
+	if !errors.As(err, &ee) || len(ee.Tail) > tailSize ||
+		!strings.HasSuffix(ee.Tail, "line 499") || strings.Contains(ee.Tail, "line 0\n") {
+		t.Fatalf("exit error %v", err)
+	}
+
+	// never run at all
+	_, err = New("tortools-no-such-command").Run(context.Background())
+	if err == nil || errors.As(err, &ee) {
+		t.Fatalf("missing command error %v", err)
+	}
+}
+
+func TestCombined(t *testing.T) {
+	res, err := sh(`echo out; echo err >&2; exit 1`).Combined().Run(context.Background())
+	var ee *ExitError
+	if !errors.As(err, &ee) || ee.Tail != "out\nerr" {
+		t.Fatalf("error %v", err)
+	}
+	if string(res.Stdout) != "out\nerr\n" || len(res.Stderr) != 0 {
+		t.Fatalf("output %q %q", res.Stdout, res.Stderr)
+	}
+}
+
+func TestOnLine(t *testing.T) {
+	var (
+		lines    []string
+		out, err bytes.Buffer
+	)
+
+	_, e := sh(`printf 'a\nb\r\n'; printf 'c\n' >&2; printf 'd'`).
+		OnLine(func(line string) {
+			lines = append(lines, line)
+		}).Tee(&out, &err).Run(context.Background())
+	if e != nil {
+		t.Fatal(e)
+	}
+	// the unfinished last line is flushed once the command exits
+	if len(lines) != 4 || lines[3] != "d" {
+		t.Fatalf("lines %q", lines)
+	}
+	for _, line := range []string{"a", "b", "c"} {
+		found := false
+		for _, l := range lines {
+			found = found || l == line
+		}
+		if !found {
+			t.Fatalf("line %q missing in %q", line, lines)
+		}
+	}
+	if out.String() != "a\nb\r\nd" || err.String() != "c\n" {
+		t.Fatalf("tee %q %q", out.String(), err.String())
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	start := time.Now()
+	_, err := New("sleep", "10").Timeout(100 * time.Millisecond).Run(context.Background())
+	if err == nil || err.Error() != "command sleep timed out" {
+		t.Fatalf("error %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("command outlived its timeout")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if _, err = New("sleep", "10").Run(ctx); err != context.Canceled {
+		t.Fatalf("error %v", err)
+	}
+}
+
+func TestArgv(t *testing.T) {
+	c := New("xmlrpc", "localhost", "d.name", "HASH")
+	if !reflect.DeepEqual(c.Argv(), []string{"xmlrpc", "localhost", "d.name", "HASH"}) ||
+		c.String() != "xmlrpc localhost d.name HASH" {
+		t.Fatalf("argv %v", c.Argv())
+	}
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,153 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package run
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Runner executes commands, the Local runner starts real processes,
+// the Fake one replies with scripted output
+type Runner interface {
+	Run(ctx context.Context, c *Command) (Result, error)
+}
+
+// Default is used by Command.Run and by callers without a runner
+var Default Runner = Local{}
+
+type Local struct{}
+
+func (Local) Run(ctx context.Context, c *Command) (Result, error) {
+	return c.exec(ctx)
+}
+
+// Or returns rn, or Default when rn is nil
+func Or(rn Runner) Runner {
+	if rn == nil {
+		return Default
+	}
+	return rn
+}
+
+// Rule is a scripted reply of Fake to commands whose argv, joined by
+// spaces, matches Pattern. Replies queued by Then are given by the
+// following matches, the last reply is repeated.
+type Rule struct {
+	Pattern *regexp.Regexp
+	Stdout  string
+	Stderr  string
+	Code    int
+	Err     error
+	next    []Rule
+}
+
+// Fake records every command run and replies by the first matching
+// rule, commands without a rule fail
+type Fake struct {
+	mu    sync.Mutex
+	rules []*Rule
+	calls [][]string
+}
+
+// On adds a rule for argv matching the regular expression pattern
+func (f *Fake) On(pattern string) *Rule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rule := &Rule{Pattern: regexp.MustCompile(pattern)}
+	f.rules = append(f.rules, rule)
+	return rule
+}
+
+func (r *Rule) Reply(stdout string, code int) *Rule {
+	r.Stdout = stdout
+	r.Code = code
+	return r
+}
+
+func (r *Rule) ReplyErr(stderr string, code int) *Rule {
+	r.Stderr = stderr
+	r.Code = code
+	return r
+}
+
+// Then queues stdout and exit code as the reply to the next match
+func (r *Rule) Then(stdout string, code int) *Rule {
+	r.next = append(r.next, Rule{Stdout: stdout, Code: code})
+	return r
+}
+
+// ThenErr queues stderr and exit code as the reply to the next match
+func (r *Rule) ThenErr(stderr string, code int) *Rule {
+	r.next = append(r.next, Rule{Stderr: stderr, Code: code})
+	return r
+}
+
+// Calls returns argv of every command run so far
+func (f *Fake) Calls() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([][]string{}, f.calls...)
+}
+
+func (f *Fake) Run(ctx context.Context, c *Command) (Result, error) {
+	argv := c.Argv()
+
+	f.mu.Lock()
+	f.calls = append(f.calls, argv)
+	var rule *Rule
+	for _, r := range f.rules {
+		if r.Pattern.MatchString(strings.Join(argv, " ")) {
+			// the reply is taken before the queue moves on
+			reply := *r
+			rule = &reply
+			if len(r.next) > 0 {
+				r.Stdout, r.Stderr = r.next[0].Stdout, r.next[0].Stderr
+				r.Code, r.Err = r.next[0].Code, r.next[0].Err
+				r.next = r.next[1:]
+			}
+			break
+		}
+	}
+	f.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+	if rule == nil {
+		return Result{}, fmt.Errorf("no rule for %s", strings.Join(argv, " "))
+	}
+
+	res := c.output([]byte(rule.Stdout), []byte(rule.Stderr))
+	if rule.Err != nil {
+		return res, rule.Err
+	}
+	if rule.Code != 0 {
+		out := res.Stderr
+		if c.combined {
+			out = res.Stdout
+		}
+		return res, &ExitError{Name: c.name, Code: rule.Code, Tail: tail(out)}
+	}
+	return res, nil
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,50 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package run
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFakeSequence(t *testing.T) {
+	f := &Fake{}
+	f.On(`^list$`).Reply("a", 0).Then("a b", 0).ThenErr("gone", 1)
+
+	for i, want := range []string{"a", "a b"} {
+		res, err := f.Run(context.Background(), New("list"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(res.Stdout) != want {
+			t.Fatalf("reply %d is %q, expected %q", i, res.Stdout, want)
+		}
+	}
+	// the last reply repeats
+	for i := 0; i < 2; i++ {
+		if _, err := f.Run(context.Background(), New("list")); err == nil {
+			t.Fatal("failing reply succeeded")
+		}
+	}
+	if _, err := f.Run(context.Background(), New("other")); err == nil {
+		t.Fatal("command without a rule succeeded")
+	}
+	if n := len(f.Calls()); n != 5 {
+		t.Fatalf("%d calls recorded, expected 5", n)
+	}
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,189 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/filvarga/tortools/download"
+	"github.com/filvarga/tortools/httpx"
+	"github.com/filvarga/tortools/metadata"
+)
+
+// FeedHTTP fetches release feeds, uncached as they are polled for news
+var FeedHTTP = func() *httpx.Client {
+	c := httpx.New()
+	c.TTL = 0
+	return c
+}()
+
+// Item is a release of a feed, GUID identifies it within the feed
+type Item struct {
+	GUID    string
+	Torrent Torrent
+}
+
+// Hash is the info-hash of the release, empty for .torrent links until
+// they are resolved
+func (i *Item) Hash() string {
+	return download.MagnetHash(i.Torrent.Magnet)
+}
+
+// Resolve fetches the .torrent the release links to and replaces the
+// link by a magnet of its info-hash, size and trackers
+func (i *Item) Resolve() error {
+	link := i.Torrent.Magnet
+	b, err := FeedHTTP.Get(context.Background(), link)
+	if err != nil {
+		return err
+	}
+	info, trackers, err := metadata.ParseTorrent(b)
+	if err != nil {
+		return fmt.Errorf("%s: %v", link, err)
+	}
+	i.Torrent.Magnet = fmt.Sprintf("%s&xl=%d",
+		download.Magnet(info.Hash, i.Torrent.Title, trackers), info.Size)
+	i.Torrent.Size = info.Size
+	return nil
+}
+
+type xmlLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr"`
+	Type   string `xml:"type,attr"`
+	Length int64  `xml:"length,attr"`
+}
+
+type xmlEntry struct {
+	Title     string    `xml:"title"`
+	ID        string    `xml:"id"`
+	Updated   string    `xml:"updated"`
+	Published string    `xml:"published"`
+	Links     []xmlLink `xml:"link"`
+	Attrs     []xmlAttr `xml:"attr"`
+	xmlTorrent
+	Torrent xmlTorrent `xml:"torrent"`
+}
+
+type xmlDoc struct {
+	XMLName xml.Name
+	// rss 2.0 items are in the channel, rss 1.0 ones next to it
+	Items    []xmlItem  `xml:"channel>item"`
+	RDFItems []xmlItem  `xml:"item"`
+	Entries  []xmlEntry `xml:"entry"`
+}
+
+// item turns the atom entry into an rss one, links to magnets and
+// torrents take the place of the link and the enclosure
+func (e *xmlEntry) item() (xmlItem, time.Time) {
+	x := xmlItem{
+		Title:      e.Title,
+		GUID:       e.ID,
+		Attrs:      e.Attrs,
+		xmlTorrent: e.xmlTorrent,
+		Torrent:    e.Torrent,
+	}
+	for _, l := range e.Links {
+		switch {
+		case strings.HasPrefix(l.Href, "magnet:"):
+			x.Link = l.Href
+		case l.Rel == "enclosure" || l.Type == "application/x-bittorrent":
+			x.Enclosure.URL = l.Href
+			x.Enclosure.Length = l.Length
+		case l.Rel == "" || l.Rel == "alternate":
+			x.Comments = l.Href
+		}
+	}
+	updated := e.Published
+	if len(updated) == 0 {
+		updated = e.Updated
+	}
+	d, _ := time.Parse(time.RFC3339, updated)
+	return x, d
+}
+
+// tellsSeeders is whether the item has the number of seeders at all
+func (x *xmlItem) tellsSeeders() bool {
+	for _, a := range x.Attrs {
+		if a.Name == "seeders" || a.Name == "peers" {
+			return true
+		}
+	}
+	return x.Seeds > 0 || x.Torrent.Seeds > 0
+}
+
+func (x *xmlItem) feedItem(provider string) (Item, bool) {
+	t, ok := x.torrent(provider)
+	if !x.tellsSeeders() {
+		// unknown, not a dead release
+		t.Seeders = -1
+	}
+	guid := strings.TrimSpace(x.GUID)
+	if len(guid) == 0 {
+		guid = t.Magnet
+	}
+	return Item{GUID: guid, Torrent: t}, ok
+}
+
+// ParseItems decodes an RSS (2.0 or 1.0) or Atom feed of releases
+func ParseItems(b []byte, provider string) ([]Item, error) {
+	var (
+		x     xmlDoc
+		items []Item
+	)
+
+	if err := xml.Unmarshal(b, &x); err != nil {
+		return nil, err
+	}
+	switch x.XMLName.Local {
+	case "rss", "RDF":
+		for _, xi := range append(x.Items, x.RDFItems...) {
+			if i, ok := xi.feedItem(provider); ok {
+				items = append(items, i)
+			}
+		}
+	case "feed":
+		for _, e := range x.Entries {
+			xi, updated := e.item()
+			if i, ok := xi.feedItem(provider); ok {
+				i.Torrent.Uploaded = updated
+				items = append(items, i)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%s is not a feed", x.XMLName.Local)
+	}
+	return items, nil
+}
+
+// FetchItems fetches and decodes the feed at url
+func FetchItems(url string, provider string) ([]Item, error) {
+	b, err := FeedHTTP.Get(context.Background(), url)
+	if err != nil {
+		return nil, fmt.Errorf("feed %s: %v", provider, err)
+	}
+	items, err := ParseItems(b, provider)
+	if err != nil {
+		return nil, fmt.Errorf("feed %s: %v", provider, err)
+	}
+	return items, nil
+}
+
+/* vim: set ts=2: */
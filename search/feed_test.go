@@ -0,0 +1,177 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/filvarga/tortools/download"
+	"github.com/filvarga/tortools/httpx"
+)
+
+const (
+	feedHash    = "0123456789ABCDEF0123456789ABCDEF01234567"
+	torrentHash = "FC64B0286A56AB58B62A25E5F12423DDA005B235"
+)
+
+func TestParseRSS(t *testing.T) {
+	items, err := ParseItems(readFixture(t, "feed-rss.xml"), "showrss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the item without a title is left out
+	if len(items) != 3 {
+		t.Fatalf("%d items", len(items))
+	}
+
+	i := items[0]
+	if i.GUID != feedHash || i.Hash() != feedHash ||
+		i.Torrent.Title != "Show.S01E01.720p.HDTV.x264-GRP" ||
+		i.Torrent.Provider != "showrss" {
+		t.Fatalf("item %+v", i)
+	}
+	if i.Torrent.Seeders != -1 || i.Torrent.Size != -1 {
+		t.Fatalf("unknown seeders and size %+v", i.Torrent)
+	}
+	if !i.Torrent.Uploaded.Equal(time.Date(2021, 6, 14, 2, 0, 0, 0, time.UTC)) {
+		t.Fatalf("uploaded %v", i.Torrent.Uploaded)
+	}
+
+	// ezrss torrent elements tell hash, size and seeders
+	i = items[1]
+	if i.Hash() != "89ABCDEF0123456789ABCDEF0123456789ABCDEF" ||
+		i.Torrent.Seeders != 12 || i.Torrent.Leechers != 8 ||
+		i.Torrent.Size != 1<<30 || i.Torrent.Link != "https://tracker.example/details/2" {
+		t.Fatalf("item %+v", i)
+	}
+
+	// a .torrent link has no hash until resolved
+	i = items[2]
+	if len(i.Hash()) != 0 || i.Torrent.Magnet != "https://tracker.example/download/3.torrent" ||
+		i.Torrent.Seeders != -1 {
+		t.Fatalf("item %+v", i)
+	}
+}
+
+func TestParseRDF(t *testing.T) {
+	items, err := ParseItems(readFixture(t, "feed-rdf.xml"), "tracker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("%d items", len(items))
+	}
+	if items[0].Hash() != "FEDCBA9876543210FEDCBA9876543210FEDCBA98" ||
+		items[0].Torrent.Title != "Movie.2020.1080p.BluRay.x264-GRP" ||
+		items[0].Torrent.Seeders != -1 {
+		t.Fatalf("item %+v", items[0])
+	}
+	// without a guid the magnet identifies the item
+	if items[1].GUID != items[1].Torrent.Magnet {
+		t.Fatalf("guid %s", items[1].GUID)
+	}
+}
+
+func TestParseAtom(t *testing.T) {
+	items, err := ParseItems(readFixture(t, "feed-atom.xml"), "tracker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("%d items", len(items))
+	}
+
+	i := items[0]
+	if i.GUID != "urn:tracker:7" || i.Hash() != feedHash ||
+		i.Torrent.Link != "https://tracker.example/details/7" {
+		t.Fatalf("item %+v", i)
+	}
+	// told, no seeders at all
+	if i.Torrent.Seeders != 0 || i.Torrent.Leechers != 4 {
+		t.Fatalf("seeders %d leechers %d", i.Torrent.Seeders, i.Torrent.Leechers)
+	}
+	// published is preferred over updated
+	if !i.Torrent.Uploaded.Equal(time.Date(2021, 6, 30, 22, 30, 0, 0, time.UTC)) {
+		t.Fatalf("uploaded %v", i.Torrent.Uploaded)
+	}
+
+	i = items[1]
+	if i.Torrent.Magnet != "https://tracker.example/download/8.torrent" ||
+		i.Torrent.Size != 2<<30 || i.Torrent.Seeders != -1 ||
+		!i.Torrent.Uploaded.Equal(time.Date(2021, 7, 8, 10, 0, 0, 0, time.UTC)) {
+		t.Fatalf("item %+v", i)
+	}
+}
+
+func TestParseItemsInvalid(t *testing.T) {
+	if _, err := ParseItems([]byte(`<html><body>Not found</body></html>`), "x"); err == nil {
+		t.Fatal("html taken for a feed")
+	}
+	if _, err := ParseItems([]byte(`not xml`), "x"); err == nil {
+		t.Fatal("garbage taken for a feed")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	torrent := readFixture(t, "feed.torrent")
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/download/3.torrent":
+			w.Header().Set("Content-Type", "application/x-bittorrent")
+			w.Write(torrent)
+		case "/download/bad.torrent":
+			w.Write([]byte("<html>login required</html>"))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	saved := FeedHTTP
+	FeedHTTP = &httpx.Client{Retries: 0}
+	defer func() {
+		FeedHTTP = saved
+		s.Close()
+	}()
+
+	i := Item{Torrent: Torrent{Title: "Show.S01E03", Size: -1,
+		Magnet: s.URL + "/download/3.torrent"}}
+	if err := i.Resolve(); err != nil {
+		t.Fatal(err)
+	}
+	if i.Hash() != torrentHash || i.Torrent.Size != 734003200 ||
+		download.MagnetSize(i.Torrent.Magnet) != 734003200 {
+		t.Fatalf("resolved to %+v", i.Torrent)
+	}
+	if !strings.Contains(i.Torrent.Magnet, "&dn=Show.S01E03&tr=udp%3A%2F%2Ftracker.example%3A1337%2Fannounce&tr=http%3A%2F%2Fbackup.example%2Fannounce&xl=") {
+		t.Fatalf("magnet %s", i.Torrent.Magnet)
+	}
+
+	for _, path := range []string{"/download/bad.torrent", "/download/gone.torrent"} {
+		link := s.URL + path
+		i := Item{Torrent: Torrent{Title: "x", Size: -1, Magnet: link}}
+		if err := i.Resolve(); err == nil {
+			t.Fatalf("%s resolved", path)
+		}
+		if i.Torrent.Magnet != link || i.Torrent.Size != -1 {
+			t.Fatalf("failed resolve changed %+v", i.Torrent)
+		}
+	}
+}
+
+/* vim: set ts=2: */
@@ -16,12 +16,19 @@
 package search
 
 import (
+	"bytes"
+	"context"
 	"fmt"
-	"log"
+	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
+
+	"github.com/filvarga/tortools/download"
+	"github.com/filvarga/tortools/httpx"
 )
 
 const (
@@ -30,11 +37,23 @@ const (
 	TV
 )
 
+// DefaultPages bounds the result pages fetched unless the search sets
+// its own bound
+const DefaultPages = 5
+
+// HTTP is the client providers share, its Interval is the politeness
+// delay between requests to a provider
+var HTTP = httpx.New()
+
 type Search struct {
 	Type    int
 	Title   string
 	Season  int
 	Episode int
+	// Limit stops the search once that many results are found (zero
+	// means no limit), Pages bounds the result pages fetched
+	Limit int `json:",omitempty"`
+	Pages int `json:",omitempty"`
 }
 
 type Torrent struct {
@@ -43,28 +62,108 @@ type Torrent struct {
 	Magnet   string
 	Seeders  int
 	Leechers int
+	// size in bytes listed by the provider, -1 when unknown
+	Size     int64
+	Uploaded time.Time
+	Category string
+	Provider string
 }
 
 type Torrents []Torrent
 
-func get(title string) []Torrent {
-	var torrents []Torrent
+// GetSize returns the exact length of the magnet, or the size listed
+// by the provider, -1 when neither is known
+func (t *Torrent) GetSize() int64 {
+	if size := download.MagnetSize(t.Magnet); size >= 0 {
+		return size
+	}
+	return t.Size
+}
+
+var (
+	reSize = regexp.MustCompile(`(?i)^[0-9]+(?:\.[0-9]+)?\s*[KMGT]?i?B$`)
+	reAge  = regexp.MustCompile(`(?i)^(?P<num>[0-9]+|an?)\s+(?P<unit>sec|min|hour|day|week|month|year)[a-z]*(?:\s+ago)?$`)
+)
+
+// parseAge turns relative ages like "3 days" or "a month ago" into
+// the time they point at, zero time when s is not an age
+func parseAge(s string, now time.Time) time.Time {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case "today", "just now":
+		return now
+	case "yesterday":
+		return now.AddDate(0, 0, -1)
+	}
+
+	match := reAge.FindStringSubmatch(s)
+	if match == nil {
+		return time.Time{}
+	}
+	n := 1
+	if i, err := strconv.Atoi(match[1]); err == nil {
+		n = i
+	}
+	switch strings.ToLower(match[2]) {
+	case "sec":
+		return now.Add(-time.Duration(n) * time.Second)
+	case "min":
+		return now.Add(-time.Duration(n) * time.Minute)
+	case "hour":
+		return now.Add(-time.Duration(n) * time.Hour)
+	case "day":
+		return now.AddDate(0, 0, -n)
+	case "week":
+		return now.AddDate(0, 0, -7*n)
+	case "month":
+		return now.AddDate(0, -n, 0)
+	case "year":
+		return now.AddDate(-n, 0, 0)
+	}
+	return time.Time{}
+}
+
+// magnetdlURL is where magnetdl is scraped from
+var magnetdlURL = "https://www.magnetdl.com"
 
+// getPage returns results of the page (1 based) by seeders, last is set
+// when no further page can have results with seeders
+func getPage(title string, page int) (torrents []Torrent, last bool, err error) {
 	title = strings.ReplaceAll(strings.ToLower(title), " ", "-")
-	url := fmt.Sprintf("https://www.magnetdl.com/%c/%s/se/desc", title[0], title)
+	url := fmt.Sprintf("%s/%c/%s/se/desc", magnetdlURL, title[0], title)
+	if page > 1 {
+		url = fmt.Sprintf("%s/%d/", url, page)
+	}
 
-	doc, err := goquery.NewDocument(url)
+	body, err := HTTP.Get(context.Background(), url)
+	if e, ok := err.(*httpx.StatusError); ok && e.Code == http.StatusNotFound {
+		// no (more) results
+		return nil, true, nil
+	} else if err != nil {
+		return nil, true, err
+	}
+	return parsePage(body, time.Now())
+}
+
+// parsePage scrapes a result page, ages are relative to now
+func parsePage(body []byte, now time.Time) (torrents []Torrent, last bool, err error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
 	if err != nil {
-		log.Fatal(err)
-		return nil
+		return nil, true, err
 	}
 
+	rows := 0
+
 	doc.Find("tbody tr").Each(func(_ int, tr *goquery.Selection) {
-		var torrent Torrent
+		torrent := Torrent{Size: -1, Provider: "magnetdl"}
+		rows++
 
 		tr.Find("td").Each(func(ix int, td *goquery.Selection) {
 
-			switch td.AttrOr("class", "") {
+			class := td.AttrOr("class", "")
+			text := strings.TrimSpace(td.Text())
+
+			switch class {
 			case "m":
 				td.Find("a").Each(func(_ int, a *goquery.Selection) {
 					if len(torrent.Magnet) == 0 {
@@ -88,25 +187,43 @@ func get(title string) []Torrent {
 				if i, err := strconv.Atoi(td.Text()); err == nil {
 					torrent.Leechers = i
 				}
+			case "":
+				// age, file count and size columns have no class
+				if reSize.MatchString(text) {
+					torrent.Size = download.Str2Bytes(text, -1)
+				} else if t := parseAge(text, now); !t.IsZero() {
+					torrent.Uploaded = t
+				}
+			default:
+				// category columns are classed t1, t2, ...
+				if strings.HasPrefix(class, "t") {
+					torrent.Category = strings.ToLower(text)
+				}
 			}
 		})
 
-		if len(torrent.Title) > 0 && len(torrent.Magnet) > 0 &&
-			torrent.Seeders > 0 {
-			torrents = append(torrents, torrent)
+		if len(torrent.Title) > 0 && len(torrent.Magnet) > 0 {
+			if torrent.Seeders > 0 {
+				torrents = append(torrents, torrent)
+			} else {
+				// sorted by seeders, the rest has none either
+				last = true
+			}
 		}
 	})
 
-	return torrents
+	return torrents, last || rows == 0, nil
 }
 
-func (s *Search) GetTorrents() []Torrent {
-	if s.Type == TV {
-		return get(fmt.Sprintf("%s s%02de%02d", s.Title,
-			s.Season, s.Episode))
-	} else {
-		return get(s.Title)
-	}
+// MagnetDL scrapes www.magnetdl.com
+type MagnetDL struct{}
+
+func (MagnetDL) Name() string {
+	return "magnetdl"
+}
+
+func (MagnetDL) Page(s *Search, page int) ([]Torrent, bool, error) {
+	return getPage(s.query(), page)
 }
 
 /* vim: set ts=2: */
@@ -0,0 +1,160 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/filvarga/tortools/download"
+	"github.com/filvarga/tortools/httpx"
+)
+
+var testNow = time.Date(2021, 6, 15, 12, 0, 0, 0, time.UTC)
+
+func readFixture(t *testing.T, name string) []byte {
+	b, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// testHTTP points providers at a test server for the test, requests
+// are neither cached nor rate limited
+func testHTTP(t *testing.T, h http.Handler) *httptest.Server {
+	s := httptest.NewServer(h)
+	saved := HTTP
+	HTTP = &httpx.Client{Retries: 0}
+	t.Cleanup(func() {
+		HTTP = saved
+		s.Close()
+	})
+	return s
+}
+
+func sizeOf(n float64, shift uint) int64 {
+	return int64(n * float64(int64(1)<<shift))
+}
+
+func TestParsePage(t *testing.T) {
+	ts, last, err := parsePage(readFixture(t, "magnetdl.html"), testNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !last {
+		t.Fatal("page with a dead release is not the last one")
+	}
+	if len(ts) != 3 {
+		t.Fatalf("%d results", len(ts))
+	}
+
+	first := ts[0]
+	if first.Title != "Show.S01E01.1080p.WEB.H264-GRP" ||
+		first.Link != "/file/5230871/show-s01e01-1080p-web-h264-grp/" ||
+		download.MagnetHash(first.Magnet) != "0123456789ABCDEF0123456789ABCDEF01234567" ||
+		first.Seeders != 120 || first.Leechers != 15 ||
+		first.Category != "tv" || first.Provider != "magnetdl" {
+		t.Fatalf("result %+v", first)
+	}
+
+	for i, want := range []struct {
+		size     int64
+		uploaded time.Time
+	}{
+		{sizeOf(1.43, 30), testNow.AddDate(0, 0, -3)},
+		{sizeOf(512.5, 20), testNow.AddDate(0, -1, 0)},
+		{4 << 30, testNow.AddDate(-2, 0, 0)},
+	} {
+		if ts[i].Size != want.size {
+			t.Errorf("%s: size %d, want %d", ts[i].Title, ts[i].Size, want.size)
+		}
+		if !ts[i].Uploaded.Equal(want.uploaded) {
+			t.Errorf("%s: uploaded %v, want %v", ts[i].Title, ts[i].Uploaded, want.uploaded)
+		}
+	}
+}
+
+func TestParsePageEmpty(t *testing.T) {
+	ts, last, err := parsePage(readFixture(t, "magnetdl-empty.html"), testNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ts) != 0 || !last {
+		t.Fatalf("%d results, last %v", len(ts), last)
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	for s, want := range map[string]time.Time{
+		"today":        testNow,
+		"Yesterday":    testNow.AddDate(0, 0, -1),
+		"30 secs":      testNow.Add(-30 * time.Second),
+		"5 mins ago":   testNow.Add(-5 * time.Minute),
+		"an hour ago":  testNow.Add(-time.Hour),
+		"1 day":        testNow.AddDate(0, 0, -1),
+		"2 weeks":      testNow.AddDate(0, 0, -14),
+		"a month":      testNow.AddDate(0, -1, 0),
+		"3 years":      testNow.AddDate(-3, 0, 0),
+		"1.43 GB":      {},
+		"2":            {},
+		"5 fortnights": {},
+		"":             {},
+	} {
+		if got := parseAge(s, testNow); !got.Equal(want) {
+			t.Errorf("%q: %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestMagnetDLPage(t *testing.T) {
+	var paths []string
+
+	page := readFixture(t, "magnetdl.html")
+	s := testHTTP(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		paths = append(paths, req.URL.Path)
+		if req.URL.Path != "/s/show-s01e01/se/desc" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write(page)
+	}))
+	magnetdlURL = s.URL
+	defer func() { magnetdlURL = "https://www.magnetdl.com" }()
+
+	search := Search{Type: TV, Title: "Show", Season: 1, Episode: 1}
+	ts, _, err := MagnetDL{}.Page(&search, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ts) != 3 {
+		t.Fatalf("%d results", len(ts))
+	}
+
+	// missing pages end the search without an error
+	ts, last, err := MagnetDL{}.Page(&search, 2)
+	if err != nil || len(ts) != 0 || !last {
+		t.Fatalf("%d results, last %v, %v", len(ts), last, err)
+	}
+	if len(paths) != 2 || paths[1] != "/s/show-s01e01/se/desc/2/" {
+		t.Fatalf("paths %v", paths)
+	}
+}
+
+/* vim: set ts=2: */
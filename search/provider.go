@@ -0,0 +1,93 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Provider is a source of search results, pages are 1 based and last
+// is set when no further page has results
+type Provider interface {
+	Name() string
+	Page(s *Search, page int) (ts []Torrent, last bool, err error)
+}
+
+// Providers are asked in order and their results merged
+var Providers = []Provider{MagnetDL{}}
+
+func (s *Search) query() string {
+	if s.Type == TV {
+		return fmt.Sprintf("%s s%02de%02d", s.Title, s.Season, s.Episode)
+	}
+	return s.Title
+}
+
+// Find returns results accepted by accept (nil accepts all) of every
+// provider merged and sorted by seeders, Limit of them at most. Result
+// pages of a provider are followed until it has Limit results accepted
+// or Pages pages are fetched. A failing provider doesn't stop the
+// others, the first error is returned along with the results.
+func (s *Search) Find(accept func(Torrent) bool) (Torrents, error) {
+	var (
+		torrents Torrents
+		first    error
+	)
+
+	pages := s.Pages
+	if pages <= 0 {
+		pages = DefaultPages
+	}
+	for _, p := range Providers {
+		found := 0
+		for page := 1; page <= pages; page++ {
+			ts, last, err := p.Page(s, page)
+			if err != nil {
+				if first == nil {
+					first = fmt.Errorf("%s: %v", p.Name(), err)
+				}
+				break
+			}
+			for _, t := range ts {
+				if accept == nil || accept(t) {
+					torrents = append(torrents, t)
+					found++
+				}
+				if s.Limit > 0 && found >= s.Limit {
+					break
+				}
+			}
+			if last || (s.Limit > 0 && found >= s.Limit) {
+				break
+			}
+		}
+	}
+
+	sort.SliceStable(torrents, func(i, j int) bool {
+		return torrents[i].Seeders > torrents[j].Seeders
+	})
+	if s.Limit > 0 && len(torrents) > s.Limit {
+		torrents = torrents[:s.Limit]
+	}
+	return torrents, first
+}
+
+func (s *Search) GetTorrents() (Torrents, error) {
+	return s.Find(nil)
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,127 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	reEpisode = regexp.MustCompile(`(?i)^(.*?)[ ._\-\[(]+s([0-9]{1,2})[ ._-]?e([0-9]{1,3})\b`)
+	reCross   = regexp.MustCompile(`(?i)^(.*?)[ ._\-\[(]+([0-9]{1,2})x([0-9]{2,3})\b`)
+	reSeason  = regexp.MustCompile(`(?i)^(.*?)[ ._\-\[(]+(?:s|season[ ._]?)([0-9]{1,2})\b`)
+	// the last year, titles may have one too
+	reYear    = regexp.MustCompile(`^(.*)[ ._\-\[(]+((?:19|20)[0-9]{2})\b`)
+	reNonWord = regexp.MustCompile(`[^a-z0-9]+`)
+
+	reResolution = regexp.MustCompile(`(?i)\b(2160p|4k|1080p|720p|576p|480p)\b`)
+	reSource     = regexp.MustCompile(`(?i)\b(blu-?ray|bdrip|brrip|web-?dl|webrip|web|hdtv|dvdrip|hdrip|cam|ts|telesync)\b`)
+	reCodec      = regexp.MustCompile(`(?i)\b(x264|x265|h\.?264|h\.?265|hevc|avc|xvid)\b`)
+)
+
+// Quality is what a release name tells about its video
+type Quality struct {
+	Resolution string
+	Source     string
+	Codec      string
+}
+
+func normalize(s string) string {
+	s = strings.ToLower(s)
+	s = strings.ReplaceAll(s, "-", "")
+	return strings.ReplaceAll(s, ".", "")
+}
+
+func ParseQuality(title string) Quality {
+	var q Quality
+	if m := reResolution.FindString(title); len(m) > 0 {
+		q.Resolution = strings.ToLower(m)
+		if q.Resolution == "4k" {
+			q.Resolution = "2160p"
+		}
+	}
+	if m := reSource.FindString(title); len(m) > 0 {
+		q.Source = normalize(m)
+	}
+	if m := reCodec.FindString(title); len(m) > 0 {
+		q.Codec = normalize(m)
+	}
+	return q
+}
+
+// Release is what a release name tells about its content, Season and
+// Episode are -1 when not told (a season pack has no episode)
+type Release struct {
+	Title   string
+	Year    int
+	Season  int
+	Episode int
+	Quality Quality
+}
+
+// NormalizeTitle lowers the title and turns separators and punctuation
+// into single spaces, so titles compare the same however written
+func NormalizeTitle(title string) string {
+	title = strings.ReplaceAll(strings.ToLower(title), "&", " and ")
+	title = strings.ReplaceAll(title, "'", "")
+	return strings.TrimSpace(reNonWord.ReplaceAllString(title, " "))
+}
+
+func ParseRelease(name string) Release {
+	r := Release{Season: -1, Episode: -1, Quality: ParseQuality(name)}
+
+	title := name
+	if m := reEpisode.FindStringSubmatch(name); m != nil {
+		title = m[1]
+		r.Season, _ = strconv.Atoi(m[2])
+		r.Episode, _ = strconv.Atoi(m[3])
+	} else if m := reCross.FindStringSubmatch(name); m != nil {
+		title = m[1]
+		r.Season, _ = strconv.Atoi(m[2])
+		r.Episode, _ = strconv.Atoi(m[3])
+	} else if m := reSeason.FindStringSubmatch(name); m != nil {
+		title = m[1]
+		r.Season, _ = strconv.Atoi(m[2])
+	}
+	// the year follows the title of movies and some series
+	if m := reYear.FindStringSubmatch(title); m != nil && len(m[1]) > 0 {
+		title = m[1]
+		r.Year, _ = strconv.Atoi(m[2])
+	} else if m := reYear.FindStringSubmatch(name); m != nil && r.Season < 0 &&
+		len(m[1]) > 0 {
+		title = m[1]
+		r.Year, _ = strconv.Atoi(m[2])
+	}
+	r.Title = NormalizeTitle(title)
+	return r
+}
+
+func (q Quality) String() string {
+	var parts []string
+	for _, p := range []string{q.Resolution, q.Source, q.Codec} {
+		if len(p) > 0 {
+			parts = append(parts, p)
+		}
+	}
+	if len(parts) == 0 {
+		return "-"
+	}
+	return strings.Join(parts, " ")
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,72 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"testing"
+)
+
+func TestParseRelease(t *testing.T) {
+	for name, want := range map[string]Release{
+		"Show.Name.S01E02.720p.HDTV.x264-GRP": {Title: "show name",
+			Season: 1, Episode: 2, Quality: Quality{"720p", "hdtv", "x264"}},
+		"Show Name s1e102 1080p WEB-DL": {Title: "show name",
+			Season: 1, Episode: 102, Quality: Quality{"1080p", "webdl", ""}},
+		"Show.Name.2x05.HDTV.XviD": {Title: "show name",
+			Season: 2, Episode: 5, Quality: Quality{"", "hdtv", "xvid"}},
+		"Show.Name.S03.COMPLETE.1080p.BluRay.H.265": {Title: "show name",
+			Season: 3, Episode: -1, Quality: Quality{"1080p", "bluray", "h265"}},
+		"Show Name Season 4 720p": {Title: "show name",
+			Season: 4, Episode: -1, Quality: Quality{Resolution: "720p"}},
+		"Show.Name.2019.S01E01.4K.WEB.HEVC": {Title: "show name", Year: 2019,
+			Season: 1, Episode: 1, Quality: Quality{"2160p", "web", "hevc"}},
+		"Movie.Name.2020.1080p.Blu-Ray.x265-GRP": {Title: "movie name", Year: 2020,
+			Season: -1, Episode: -1, Quality: Quality{"1080p", "bluray", "x265"}},
+		"Blade Runner 2049 (2017) 2160p": {Title: "blade runner 2049", Year: 2017,
+			Season: -1, Episode: -1, Quality: Quality{Resolution: "2160p"}},
+		"2012.2009.720p.BRRip": {Title: "2012", Year: 2009,
+			Season: -1, Episode: -1, Quality: Quality{"720p", "brrip", ""}},
+		"Just a title": {Title: "just a title", Season: -1, Episode: -1},
+	} {
+		if got := ParseRelease(name); got != want {
+			t.Fatalf("%s: parsed %+v, expected %+v", name, got, want)
+		}
+	}
+}
+
+func TestQualityString(t *testing.T) {
+	if s := ParseQuality("Movie.1080p.WEBRip.H.264").String(); s != "1080p webrip h264" {
+		t.Fatalf("quality %s", s)
+	}
+	if s := ParseQuality("Movie").String(); s != "-" {
+		t.Fatalf("unknown quality %s", s)
+	}
+}
+
+func TestNormalizeTitle(t *testing.T) {
+	for title, want := range map[string]string{
+		"Law & Order":                     "law and order",
+		"Grey's.Anatomy":                  "greys anatomy",
+		"  Mr._Robot -- ":                 "mr robot",
+		"Marvel's Agents of S.H.I.E.L.D.": "marvels agents of s h i e l d",
+	} {
+		if got := NormalizeTitle(title); got != want {
+			t.Fatalf("%q normalized to %q, expected %q", title, got, want)
+		}
+	}
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,447 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPageSize is asked for when the indexer doesn't tell its own
+const DefaultPageSize = 100
+
+// Torznab queries a Torznab (or Newznab) API, URL is the endpoint with
+// or without the trailing /api
+type Torznab struct {
+	Title      string
+	URL        string
+	APIKey     string
+	Categories []int
+	PageSize   int
+
+	mu   sync.Mutex
+	caps *Caps
+}
+
+// Mode is a search function of the indexer and its parameters
+type Mode struct {
+	Available bool
+	Params    []string
+}
+
+func (m Mode) Supports(param string) bool {
+	for _, p := range m.Params {
+		if strings.EqualFold(p, param) {
+			return true
+		}
+	}
+	return false
+}
+
+type Category struct {
+	ID   int
+	Name string
+	Sub  []Category
+}
+
+// Caps is the capabilities document of the indexer
+type Caps struct {
+	Server      string
+	Max         int
+	Default     int
+	Search      Mode
+	TVSearch    Mode
+	MovieSearch Mode
+	Categories  []Category
+}
+
+type xmlMode struct {
+	Available       string `xml:"available,attr"`
+	SupportedParams string `xml:"supportedParams,attr"`
+}
+
+type xmlCategory struct {
+	ID   int           `xml:"id,attr"`
+	Name string        `xml:"name,attr"`
+	Sub  []xmlCategory `xml:"subcat"`
+}
+
+type xmlCaps struct {
+	XMLName xml.Name `xml:"caps"`
+	Server  struct {
+		Title string `xml:"title,attr"`
+	} `xml:"server"`
+	Limits struct {
+		Max     int `xml:"max,attr"`
+		Default int `xml:"default,attr"`
+	} `xml:"limits"`
+	Searching struct {
+		Search      xmlMode `xml:"search"`
+		TVSearch    xmlMode `xml:"tv-search"`
+		MovieSearch xmlMode `xml:"movie-search"`
+	} `xml:"searching"`
+	Categories []xmlCategory `xml:"categories>category"`
+}
+
+type xmlAttr struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type xmlItem struct {
+	Title     string `xml:"title"`
+	GUID      string `xml:"guid"`
+	Link      string `xml:"link"`
+	Comments  string `xml:"comments"`
+	PubDate   string `xml:"pubDate"`
+	Size      int64  `xml:"size"`
+	Enclosure struct {
+		URL    string `xml:"url,attr"`
+		Length int64  `xml:"length,attr"`
+	} `xml:"enclosure"`
+	// torznab:attr and newznab:attr alike
+	Attrs []xmlAttr `xml:"attr"`
+	// torrent elements of release feeds (ezrss, showrss), either right
+	// in the item or nested in a torrent element
+	xmlTorrent
+	Torrent xmlTorrent `xml:"torrent"`
+}
+
+type xmlTorrent struct {
+	MagnetURI     string `xml:"magnetURI"`
+	InfoHash      string `xml:"infoHash"`
+	InfoHashAlt   string `xml:"info_hash"`
+	ContentLength int64  `xml:"contentLength"`
+	Seeds         int    `xml:"seeds"`
+	Peers         int    `xml:"peers"`
+}
+
+type xmlFeed struct {
+	XMLName  xml.Name
+	Code     string `xml:"code,attr"`
+	Describe string `xml:"description,attr"`
+	Response struct {
+		Offset int `xml:"offset,attr"`
+		Total  int `xml:"total,attr"`
+	} `xml:"channel>response"`
+	Items []xmlItem `xml:"channel>item"`
+}
+
+func (t *Torznab) Name() string {
+	if len(t.Title) > 0 {
+		return t.Title
+	}
+	return "torznab"
+}
+
+func (t *Torznab) endpoint(params url.Values) string {
+	u := strings.TrimSuffix(t.URL, "/")
+	if !strings.HasSuffix(u, "/api") {
+		u += "/api"
+	}
+	if len(t.APIKey) > 0 {
+		params.Set("apikey", t.APIKey)
+	}
+	return u + "?" + params.Encode()
+}
+
+// get fetches the document, error documents are turned into errors
+func (t *Torznab) get(params url.Values) ([]byte, error) {
+	body, err := HTTP.Get(context.Background(), t.endpoint(params))
+	if err != nil && len(body) == 0 {
+		return nil, err
+	}
+	var e struct {
+		XMLName     xml.Name
+		Code        string `xml:"code,attr"`
+		Description string `xml:"description,attr"`
+	}
+	if xml.Unmarshal(body, &e) == nil && e.XMLName.Local == "error" {
+		return nil, fmt.Errorf("error %s: %s", e.Code, e.Description)
+	}
+	return body, err
+}
+
+func mode(m xmlMode) Mode {
+	var params []string
+	for _, p := range strings.Split(m.SupportedParams, ",") {
+		if p = strings.TrimSpace(p); len(p) > 0 {
+			params = append(params, p)
+		}
+	}
+	return Mode{Available: m.Available == "yes", Params: params}
+}
+
+func categories(xs []xmlCategory) []Category {
+	var cs []Category
+	for _, x := range xs {
+		cs = append(cs, Category{ID: x.ID, Name: x.Name, Sub: categories(x.Sub)})
+	}
+	return cs
+}
+
+// ParseCaps decodes the capabilities document
+func ParseCaps(b []byte) (*Caps, error) {
+	var x xmlCaps
+	if err := xml.Unmarshal(b, &x); err != nil {
+		return nil, err
+	}
+	return &Caps{
+		Server:      x.Server.Title,
+		Max:         x.Limits.Max,
+		Default:     x.Limits.Default,
+		Search:      mode(x.Searching.Search),
+		TVSearch:    mode(x.Searching.TVSearch),
+		MovieSearch: mode(x.Searching.MovieSearch),
+		Categories:  categories(x.Categories),
+	}, nil
+}
+
+// Caps returns the capabilities of the indexer, asked for until they
+// are known
+func (t *Torznab) Caps() (*Caps, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.caps != nil {
+		return t.caps, nil
+	}
+	b, err := t.get(url.Values{"t": {"caps"}})
+	if err != nil {
+		return nil, err
+	}
+	if t.caps, err = ParseCaps(b); err != nil {
+		return nil, err
+	}
+	return t.caps, nil
+}
+
+// categoryOf names the newznab category the way magnetdl does
+func categoryOf(id int) string {
+	switch id / 1000 {
+	case 2:
+		return "movie"
+	case 3:
+		return "music"
+	case 4:
+		return "software"
+	case 5:
+		return "tv"
+	case 6:
+		return "xxx"
+	case 7:
+		return "books"
+	}
+	return "other"
+}
+
+func (x *xmlItem) torrent(provider string) (Torrent, bool) {
+	var (
+		hash     string
+		category string
+	)
+
+	t := Torrent{
+		Title:    strings.TrimSpace(x.Title),
+		Link:     x.Comments,
+		Size:     -1,
+		Provider: provider,
+	}
+	if len(t.Link) == 0 {
+		t.Link = x.GUID
+	}
+	if x.Size > 0 {
+		t.Size = x.Size
+	} else if x.Enclosure.Length > 0 {
+		t.Size = x.Enclosure.Length
+	}
+	if len(x.PubDate) > 0 {
+		if d, err := time.Parse(time.RFC1123Z, x.PubDate); err == nil {
+			t.Uploaded = d
+		} else if d, err := time.Parse(time.RFC1123, x.PubDate); err == nil {
+			t.Uploaded = d
+		}
+	}
+
+	peers := -1
+	for _, a := range x.Attrs {
+		switch a.Name {
+		case "seeders":
+			t.Seeders, _ = strconv.Atoi(a.Value)
+		case "peers":
+			peers, _ = strconv.Atoi(a.Value)
+		case "leechers":
+			t.Leechers, _ = strconv.Atoi(a.Value)
+		case "size":
+			if i, err := strconv.ParseInt(a.Value, 10, 64); err == nil {
+				t.Size = i
+			}
+		case "infohash":
+			hash = a.Value
+		case "magneturl":
+			t.Magnet = a.Value
+		case "category":
+			// the first (main) category names the torrent
+			if len(category) == 0 {
+				if id, err := strconv.Atoi(a.Value); err == nil {
+					category = categoryOf(id)
+				}
+			}
+		}
+	}
+	t.Category = category
+	for _, e := range []xmlTorrent{x.xmlTorrent, x.Torrent} {
+		if len(t.Magnet) == 0 {
+			t.Magnet = strings.TrimSpace(e.MagnetURI)
+		}
+		if len(hash) == 0 {
+			hash = strings.TrimSpace(e.InfoHash + e.InfoHashAlt)
+		}
+		if t.Size < 0 && e.ContentLength > 0 {
+			t.Size = e.ContentLength
+		}
+		if t.Seeders == 0 && e.Seeds > 0 {
+			t.Seeders = e.Seeds
+		}
+		if peers < 0 && e.Peers > 0 {
+			peers = e.Peers
+		}
+	}
+	if peers > t.Seeders && t.Leechers == 0 {
+		// peers count seeders as well
+		t.Leechers = peers - t.Seeders
+	}
+
+	switch {
+	case len(t.Magnet) > 0:
+	case strings.HasPrefix(x.Link, "magnet:"):
+		t.Magnet = x.Link
+	case len(hash) > 0:
+		t.Magnet = fmt.Sprintf("magnet:?xt=urn:btih:%s&dn=%s", hash,
+			url.QueryEscape(t.Title))
+	case len(x.Enclosure.URL) > 0:
+		// clients load .torrent links just like magnets
+		t.Magnet = x.Enclosure.URL
+	default:
+		t.Magnet = x.Link
+	}
+	return t, len(t.Title) > 0 && len(t.Magnet) > 0
+}
+
+// ParseFeed decodes a result feed, total is the number of results the
+// indexer has in all (-1 when not told)
+func ParseFeed(b []byte, provider string) (ts []Torrent, total int, err error) {
+	ts, _, total, err = parseFeed(b, provider)
+	return ts, total, err
+}
+
+// parseFeed also returns the number of items in the feed, items that
+// can't be downloaded are left out of the results
+func parseFeed(b []byte, provider string) (ts []Torrent, items int, total int, err error) {
+	var x xmlFeed
+	if err = xml.Unmarshal(b, &x); err != nil {
+		return nil, 0, -1, err
+	}
+	if x.XMLName.Local == "error" {
+		return nil, 0, -1, fmt.Errorf("error %s: %s", x.Code, x.Describe)
+	}
+	for i := range x.Items {
+		if t, ok := x.Items[i].torrent(provider); ok {
+			ts = append(ts, t)
+		}
+	}
+	total = -1
+	if x.Response.Total > 0 {
+		total = x.Response.Total
+	}
+	return ts, len(x.Items), total, nil
+}
+
+// params picks the search function for the search, tv and movie search
+// are used when the indexer supports them
+func (t *Torznab) params(s *Search) url.Values {
+	params := url.Values{"t": {"search"}, "q": {s.query()}}
+
+	caps, err := t.Caps()
+	if err != nil {
+		// without caps the plain search is the safe bet
+		return params
+	}
+	switch {
+	case s.Type == TV && caps.TVSearch.Available &&
+		caps.TVSearch.Supports("season") && caps.TVSearch.Supports("ep"):
+		params.Set("t", "tvsearch")
+		params.Set("q", s.Title)
+		params.Set("season", strconv.Itoa(s.Season))
+		params.Set("ep", strconv.Itoa(s.Episode))
+	case s.Type == Movie && caps.MovieSearch.Available:
+		params.Set("t", "movie")
+	}
+	return params
+}
+
+func (t *Torznab) pageSize() int {
+	if t.PageSize > 0 {
+		return t.PageSize
+	}
+	if caps, err := t.Caps(); err == nil {
+		if caps.Default > 0 {
+			return caps.Default
+		}
+		if caps.Max > 0 {
+			return caps.Max
+		}
+	}
+	return DefaultPageSize
+}
+
+func (t *Torznab) Page(s *Search, page int) ([]Torrent, bool, error) {
+	size := t.pageSize()
+	offset := (page - 1) * size
+
+	params := t.params(s)
+	params.Set("extended", "1")
+	params.Set("offset", strconv.Itoa(offset))
+	params.Set("limit", strconv.Itoa(size))
+	if len(t.Categories) > 0 {
+		var cats []string
+		for _, c := range t.Categories {
+			cats = append(cats, strconv.Itoa(c))
+		}
+		params.Set("cat", strings.Join(cats, ","))
+	}
+
+	body, err := t.get(params)
+	if err != nil {
+		return nil, true, err
+	}
+	ts, items, total, err := parseFeed(bytes.TrimSpace(body), t.Name())
+	if err != nil {
+		return nil, true, err
+	}
+	// items without a magnet don't mean the indexer ran out of results
+	last := items < size || (total >= 0 && offset+items >= total)
+	return ts, last, nil
+}
+
+/* vim: set ts=2: */
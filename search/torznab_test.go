@@ -0,0 +1,237 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/filvarga/tortools/download"
+)
+
+// indexer serves the fixtures like Jackett does, the queries asked
+// are recorded
+func indexer(t *testing.T) (*Torznab, *[]url.Values) {
+	var queries []url.Values
+
+	caps := readFixture(t, "torznab-caps.xml")
+	tvsearch := readFixture(t, "torznab-tvsearch.xml")
+	search := readFixture(t, "torznab-search.xml")
+
+	s := testHTTP(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		queries = append(queries, q)
+		if req.URL.Path != "/api/v2.0/indexers/test/results/torznab/api" {
+			http.NotFound(w, req)
+			return
+		}
+		if q.Get("apikey") != "key" {
+			fmt.Fprint(w, `<error code="100" description="Invalid API Key" />`)
+			return
+		}
+		switch q.Get("t") {
+		case "caps":
+			w.Write(caps)
+		case "tvsearch":
+			w.Write(tvsearch)
+		case "search", "movie":
+			w.Write(search)
+		default:
+			fmt.Fprint(w, `<error code="202" description="No such function" />`)
+		}
+	}))
+	return &Torznab{Title: "test", APIKey: "key",
+		URL: s.URL + "/api/v2.0/indexers/test/results/torznab/"}, &queries
+}
+
+func TestParseCaps(t *testing.T) {
+	caps, err := ParseCaps(readFixture(t, "torznab-caps.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caps.Server != "Jackett" || caps.Default != 2 || caps.Max != 100 {
+		t.Fatalf("caps %+v", caps)
+	}
+	if !caps.Search.Available || !caps.TVSearch.Supports("season") ||
+		!caps.TVSearch.Supports("EP") || caps.MovieSearch.Supports("season") {
+		t.Fatalf("modes %+v %+v %+v", caps.Search, caps.TVSearch, caps.MovieSearch)
+	}
+	if len(caps.Categories) != 2 || caps.Categories[0].ID != 2000 ||
+		len(caps.Categories[0].Sub) != 2 || caps.Categories[1].Sub[0].Name != "TV/HD" {
+		t.Fatalf("categories %+v", caps.Categories)
+	}
+}
+
+func TestTorznabCaps(t *testing.T) {
+	tz, queries := indexer(t)
+
+	for i := 0; i < 2; i++ {
+		caps, err := tz.Caps()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if caps.Server != "Jackett" {
+			t.Fatalf("caps %+v", caps)
+		}
+	}
+	if len(*queries) != 1 {
+		t.Fatalf("caps asked %d times", len(*queries))
+	}
+
+	tz.APIKey = "wrong"
+	tz.caps = nil
+	if _, err := tz.Caps(); err == nil || err.Error() != "error 100: Invalid API Key" {
+		t.Fatalf("error %v", err)
+	}
+}
+
+func TestTorznabTVSearch(t *testing.T) {
+	tz, queries := indexer(t)
+	tz.Categories = []int{5000, 5040}
+
+	s := Search{Type: TV, Title: "Show", Season: 1, Episode: 1}
+	ts, last, err := tz.Page(&s, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := (*queries)[len(*queries)-1]
+	if q.Get("t") != "tvsearch" || q.Get("q") != "Show" || q.Get("season") != "1" ||
+		q.Get("ep") != "1" || q.Get("cat") != "5000,5040" ||
+		q.Get("offset") != "0" || q.Get("limit") != "2" || q.Get("extended") != "1" {
+		t.Fatalf("query %v", q)
+	}
+	if len(ts) != 2 {
+		t.Fatalf("%d results", len(ts))
+	}
+
+	first := ts[0]
+	if first.Title != "Show.S01E01.1080p.WEB.H264-GRP" ||
+		first.Link != "https://indexer.example/details/1" ||
+		download.MagnetHash(first.Magnet) != "0123456789ABCDEF0123456789ABCDEF01234567" ||
+		first.Size != 1535450808 || first.Seeders != 120 || first.Leechers != 15 ||
+		first.Category != "tv" || first.Provider != "test" ||
+		!first.Uploaded.Equal(time.Date(2021, 6, 15, 10, 0, 0, 0, time.UTC)) {
+		t.Fatalf("result %+v", first)
+	}
+
+	// the info-hash wins over the .torrent enclosure
+	second := ts[1]
+	if download.MagnetHash(second.Magnet) != "89ABCDEF0123456789ABCDEF0123456789ABCDEF" ||
+		second.Link != "https://indexer.example/torrent/2" ||
+		second.Size != 537395200 || second.Leechers != 2 {
+		t.Fatalf("result %+v", second)
+	}
+
+	// page 2 of 2 results a page out of 3
+	if last {
+		t.Fatal("first page is the last one")
+	}
+	if _, _, err = tz.Page(&s, 2); err != nil {
+		t.Fatal(err)
+	}
+	if q := (*queries)[len(*queries)-1]; q.Get("offset") != "2" {
+		t.Fatalf("query %v", q)
+	}
+}
+
+func TestTorznabSearch(t *testing.T) {
+	tz, queries := indexer(t)
+
+	s := Search{Type: Movie, Title: "Movie 2020"}
+	ts, last, err := tz.Page(&s, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := (*queries)[len(*queries)-1]; q.Get("t") != "movie" || q.Get("q") != "Movie 2020" {
+		t.Fatalf("query %v", q)
+	}
+	// the item without a link is dropped but still counts for the page
+	if len(ts) != 1 || ts[0].Size != 8589934592 || ts[0].Seeders != 55 ||
+		ts[0].Leechers != 5 || ts[0].Category != "movie" {
+		t.Fatalf("results %+v", ts)
+	}
+	if last {
+		t.Fatal("full page with a dropped item is the last one")
+	}
+
+	s = Search{Title: "Movie 2020"}
+	if _, _, err = tz.Page(&s, 1); err != nil {
+		t.Fatal(err)
+	}
+	if q := (*queries)[len(*queries)-1]; q.Get("t") != "search" {
+		t.Fatalf("query %v", q)
+	}
+}
+
+// provider returns fixed results on a single page
+type provider struct {
+	name    string
+	results []Torrent
+	pages   int
+}
+
+func (p *provider) Name() string {
+	return p.name
+}
+
+func (p *provider) Page(s *Search, page int) ([]Torrent, bool, error) {
+	p.pages++
+	if p.results == nil {
+		return nil, true, fmt.Errorf("down")
+	}
+	return p.results, true, nil
+}
+
+func TestFind(t *testing.T) {
+	a := &provider{name: "a", results: []Torrent{
+		{Title: "a1", Seeders: 10}, {Title: "a2", Seeders: 3}, {Title: "a3", Seeders: 1},
+	}}
+	b := &provider{name: "b", results: []Torrent{
+		{Title: "b1", Seeders: 50}, {Title: "b2", Seeders: 5},
+	}}
+	down := &provider{name: "down"}
+
+	saved := Providers
+	Providers = []Provider{down, a, b}
+	defer func() { Providers = saved }()
+
+	s := Search{Title: "x", Limit: 3}
+	ts, err := s.Find(func(t Torrent) bool { return t.Title != "b2" })
+	if err == nil || err.Error() != "down: down" {
+		t.Fatalf("error %v", err)
+	}
+	var titles []string
+	for _, t := range ts {
+		titles = append(titles, t.Title)
+	}
+	if fmt.Sprint(titles) != "[b1 a1 a2]" {
+		t.Fatalf("results %v", titles)
+	}
+	if a.pages != 1 || b.pages != 1 || down.pages != 1 {
+		t.Fatalf("pages %d %d %d", a.pages, b.pages, down.pages)
+	}
+
+	// without a limit all results are merged
+	s.Limit = 0
+	if ts, _ = s.Find(nil); len(ts) != 5 || ts[0].Title != "b1" || ts[4].Title != "a3" {
+		t.Fatalf("results %+v", ts)
+	}
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,80 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Dir is where the state files are kept, $TORTOOLS_STATE overrides
+// the default $XDG_DATA_HOME/tortools
+func Dir() string {
+	if dir := os.Getenv("TORTOOLS_STATE"); len(dir) > 0 {
+		return dir
+	}
+	if dir := os.Getenv("XDG_DATA_HOME"); len(dir) > 0 {
+		return filepath.Join(dir, "tortools")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = os.TempDir()
+	}
+	return filepath.Join(home, ".local", "share", "tortools")
+}
+
+func path(name string) string {
+	return filepath.Join(Dir(), name+".json")
+}
+
+// Load decodes state file name into v, missing file leaves v untouched
+func Load(name string, v interface{}) error {
+	b, err := ioutil.ReadFile(path(name))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// Save encodes v into state file name, the file is replaced atomically
+func Save(name string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(Dir(), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(Dir(), name+".*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path(name))
+}
+
+/* vim: set ts=2: */
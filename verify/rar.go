@@ -0,0 +1,175 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package verify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// headers of the first files are within that many bytes of the start
+const rarHeaderLen = 64 * 1024
+
+var (
+	rar4Signature = []byte("Rar!\x1a\x07\x00")
+	rar5Signature = []byte("Rar!\x1a\x07\x01\x00")
+)
+
+// rar4 block header types and flags
+const (
+	rar4Main         = 0x73
+	rar4File         = 0x74
+	rar4MainPassword = 0x0080
+	rar4FilePassword = 0x0004
+	rar4LongBlock    = 0x8000
+)
+
+// rar5 header types and the extra record of encrypted files
+const (
+	rar5File       = 2
+	rar5Encryption = 4
+	rar5Crypt      = 1
+)
+
+// rar4Passworded walks the block headers up to the first file
+func rar4Passworded(b []byte) bool {
+	for pos := len(rar4Signature); pos+7 <= len(b); {
+		typ := b[pos+2]
+		flags := binary.LittleEndian.Uint16(b[pos+3:])
+		size := int(binary.LittleEndian.Uint16(b[pos+5:]))
+		switch typ {
+		case rar4Main:
+			if flags&rar4MainPassword != 0 {
+				return true
+			}
+		case rar4File:
+			return flags&rar4FilePassword != 0
+		}
+		if size < 7 {
+			return false
+		}
+		if flags&rar4LongBlock != 0 && pos+11 <= len(b) {
+			size += int(binary.LittleEndian.Uint32(b[pos+7:]))
+		}
+		pos += size
+	}
+	return false
+}
+
+// vint decodes the variable length integer of rar5, a negative pos
+// is the failure of the previous one and fails too
+func vint(b []byte, pos int) (uint64, int) {
+	var v uint64
+	if pos < 0 {
+		return 0, -1
+	}
+	for shift := uint(0); pos < len(b) && shift < 64; shift += 7 {
+		c := b[pos]
+		pos++
+		v |= uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return v, pos
+		}
+	}
+	return 0, -1
+}
+
+// rar5Passworded walks the headers up to the first file, encrypted
+// headers or an encryption record of the file tell the password
+func rar5Passworded(b []byte) bool {
+	for pos := len(rar5Signature); pos+4 < len(b); {
+		size, start := vint(b, pos+4)
+		if start < 0 || size == 0 || size > uint64(len(b)) {
+			return false
+		}
+		end := start + int(size)
+		typ, p := vint(b, start)
+		flags, p := vint(b, p)
+		if p < 0 {
+			return false
+		}
+		var extra, data uint64
+		if flags&0x01 != 0 {
+			extra, p = vint(b, p)
+		}
+		if flags&0x02 != 0 {
+			data, p = vint(b, p)
+		}
+		if p < 0 || extra > size || data > uint64(len(b)) {
+			return false
+		}
+		switch typ {
+		case rar5Encryption:
+			return true
+		case rar5File:
+			if end > len(b) {
+				return false
+			}
+			for r := end - int(extra); r >= start && r < end; {
+				rsize, q := vint(b, r)
+				if q < 0 || rsize == 0 {
+					break
+				}
+				if rtype, _ := vint(b, q); rtype == rar5Crypt {
+					return true
+				}
+				r = q + int(rsize)
+			}
+			return false
+		}
+		pos = end + int(data)
+	}
+	return false
+}
+
+// Passworded reports whether the rar archive needs a password, either
+// to list its files or to extract them
+func Passworded(path string) (bool, error) {
+	b, err := header(path, rarHeaderLen)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case bytes.HasPrefix(b, rar5Signature):
+		return rar5Passworded(b), nil
+	case bytes.HasPrefix(b, rar4Signature):
+		return rar4Passworded(b), nil
+	}
+	return false, nil
+}
+
+// Archives checks no rar archive of the payload is passworded, such
+// releases are never what they claim to be
+func Archives(paths []string) error {
+	for _, path := range paths {
+		if !strings.EqualFold(filepath.Ext(path), ".rar") {
+			continue
+		}
+		locked, err := Passworded(path)
+		if err != nil {
+			return err
+		}
+		if locked {
+			return fmt.Errorf("%s is passworded", filepath.Base(path))
+		}
+	}
+	return nil
+}
+
+/* vim: set ts=2: */
@@ -0,0 +1,183 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package verify
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// lowest bitrate (bytes per second) a real release is expected to have
+const minBitrate = 500 * 1000 / 8
+
+var executables = map[string]bool{
+	".exe": true, ".com": true, ".scr": true, ".msi": true, ".bat": true,
+	".cmd": true, ".lnk": true, ".vbs": true, ".js": true, ".jar": true,
+	".ps1": true, ".apk": true, ".pif": true, ".hta": true,
+}
+
+var archives = map[string]bool{
+	".rar": true, ".zip": true, ".7z": true, ".gz": true, ".bz2": true,
+	".xz": true, ".tar": true, ".iso": true,
+}
+
+var videos = map[string]bool{
+	".mkv": true, ".webm": true, ".mp4": true, ".m4v": true, ".mov": true,
+	".avi": true, ".wmv": true, ".ts": true, ".m2ts": true,
+}
+
+// IsArchive reports whether the file is an archive, including old style
+// split rar volumes (.r00, .r01, ...)
+func IsArchive(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	if archives[ext] {
+		return true
+	}
+	return len(ext) == 4 && ext[1] == 'r' &&
+		ext[2] >= '0' && ext[2] <= '9' && ext[3] >= '0' && ext[3] <= '9'
+}
+
+func IsVideo(path string) bool {
+	return videos[strings.ToLower(filepath.Ext(path))]
+}
+
+func IsExecutable(path string) bool {
+	return executables[strings.ToLower(filepath.Ext(path))]
+}
+
+// Names checks file names of a release known before it is downloaded:
+// no executables and at least a video or an archive
+func Names(paths []string) error {
+	found := false
+	for _, path := range paths {
+		if IsExecutable(path) {
+			return fmt.Errorf("%s is an executable", filepath.Base(path))
+		}
+		if IsVideo(path) || IsArchive(path) {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("no video found")
+	}
+	return nil
+}
+
+func header(path string, n int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b := make([]byte, n)
+	n, err = io.ReadFull(f, b)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return b[:n], err
+}
+
+// container checks the magic bytes of a video file match its extension
+func container(path string) error {
+	b, err := header(path, 189)
+	if err != nil {
+		return err
+	}
+
+	ok := false
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mkv", ".webm":
+		ok = bytes.HasPrefix(b, []byte{0x1a, 0x45, 0xdf, 0xa3})
+	case ".mp4", ".m4v", ".mov":
+		ok = len(b) >= 8 && (bytes.Equal(b[4:8], []byte("ftyp")) ||
+			bytes.Equal(b[4:8], []byte("moov")) ||
+			bytes.Equal(b[4:8], []byte("mdat")) ||
+			bytes.Equal(b[4:8], []byte("free")) ||
+			bytes.Equal(b[4:8], []byte("wide")))
+	case ".avi":
+		ok = len(b) >= 12 && bytes.Equal(b[0:4], []byte("RIFF")) &&
+			bytes.Equal(b[8:12], []byte("AVI "))
+	case ".wmv":
+		ok = bytes.HasPrefix(b, []byte{0x30, 0x26, 0xb2, 0x75})
+	case ".ts", ".m2ts":
+		// 188 byte transport stream packets, m2ts adds 4 byte prefix
+		ok = (len(b) > 188 && b[0] == 0x47 && b[188] == 0x47) ||
+			(len(b) > 4 && b[4] == 0x47)
+	}
+	if !ok {
+		return fmt.Errorf("%s is not a video", filepath.Base(path))
+	}
+	return nil
+}
+
+// Verify checks payload files of a completed download look like a real
+// release: no executables, the video container matches its extension
+// and the main video is large enough for the expected runtime. Zero
+// runtime is not a movie or series, a video isn't required then.
+func Verify(paths []string, runtime time.Duration) error {
+	var (
+		video string
+		size  int64
+		found bool
+	)
+
+	for _, path := range paths {
+		if IsExecutable(path) {
+			return fmt.Errorf("%s is an executable", filepath.Base(path))
+		}
+		if IsArchive(path) {
+			found = true
+		}
+		if !IsVideo(path) {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if err = container(path); err != nil {
+			return err
+		}
+		if info.Size() > size {
+			video = path
+			size = info.Size()
+		}
+	}
+
+	if len(video) == 0 {
+		if runtime <= 0 {
+			return nil
+		}
+		if found {
+			return fmt.Errorf("archive without a video")
+		}
+		return fmt.Errorf("no video found")
+	}
+
+	if min := int64(runtime.Seconds()) * minBitrate; size < min {
+		return fmt.Errorf("%s is too small for %s runtime",
+			filepath.Base(video), runtime)
+	}
+	return nil
+}
+
+/* vim: set ts=2: */
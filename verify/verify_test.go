@@ -0,0 +1,214 @@
+/*
+ * Copyright 2021 Filip Varga
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package verify
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func cat(parts ...[]byte) []byte {
+	var b []byte
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// rar4Block is a block header without the crc check, size covers the
+// 7 bytes of the header itself
+func rar4Block(typ byte, flags uint16, fields ...byte) []byte {
+	size := 7 + len(fields)
+	return cat([]byte{0, 0, typ, byte(flags), byte(flags >> 8),
+		byte(size), byte(size >> 8)}, fields)
+}
+
+// rar5Header is a header without the crc check, fields follow type
+func rar5Header(typ byte, fields ...byte) []byte {
+	return cat([]byte{0, 0, 0, 0, byte(1 + len(fields)), typ}, fields)
+}
+
+var (
+	rar4Main4 = rar4Block(rar4Main, 0, 0, 0, 0, 0, 0, 0)
+	rar4Plain = cat(rar4Signature, rar4Main4,
+		rar4Block(rar4File, rar4LongBlock, 5, 0, 0, 0))
+	rar4Locked = cat(rar4Signature, rar4Main4,
+		rar4Block(rar4File, rar4LongBlock|rar4FilePassword, 5, 0, 0, 0))
+	rar4LockedMain = cat(rar4Signature,
+		rar4Block(rar4Main, rar4MainPassword, 0, 0, 0, 0, 0, 0))
+
+	// main header: no flags and no archive flags
+	rar5Main4 = rar5Header(1, 0, 0)
+	// file header with an extra area of 3 bytes holding one record
+	rar5Plain = cat(rar5Signature, rar5Main4,
+		rar5Header(rar5File, 0x01, 3, 0, 5, 0x02, 0x10, 0))
+	rar5Locked = cat(rar5Signature, rar5Main4,
+		rar5Header(rar5File, 0x01, 3, 0, 5, 0x02, rar5Crypt, 0))
+	rar5LockedHeaders = cat(rar5Signature, rar5Header(rar5Encryption, 0, 0))
+)
+
+func TestRar4(t *testing.T) {
+	for name, c := range map[string]struct {
+		b    []byte
+		want bool
+	}{
+		"plain":           {rar4Plain, false},
+		"passworded":      {rar4Locked, true},
+		"passworded main": {rar4LockedMain, true},
+	} {
+		if got := rar4Passworded(c.b); got != c.want {
+			t.Fatalf("%s: passworded %v, expected %v", name, got, c.want)
+		}
+	}
+}
+
+func TestRar5(t *testing.T) {
+	for name, c := range map[string]struct {
+		b    []byte
+		want bool
+	}{
+		"plain":             {rar5Plain, false},
+		"passworded":        {rar5Locked, true},
+		"encrypted headers": {rar5LockedHeaders, true},
+		"signature only":    {rar5Signature, false},
+		"oversized header":  {cat(rar5Signature, []byte{0, 0, 0, 0, 0xff, 0xff, 0xff, 0x7f, 2}), false},
+		"unterminated vint": {cat(rar5Signature, []byte{0, 0, 0, 0, 0x80, 0x80, 0x80}), false},
+		"zero header size":  {cat(rar5Signature, []byte{0, 0, 0, 0, 0, 2}), false},
+	} {
+		if got := rar5Passworded(c.b); got != c.want {
+			t.Fatalf("%s: passworded %v, expected %v", name, got, c.want)
+		}
+	}
+}
+
+// cut archives and garbage must not panic, whatever they report
+func TestRarTruncated(t *testing.T) {
+	for _, b := range [][]byte{rar4Plain, rar4Locked, rar5Plain, rar5Locked} {
+		for n := 0; n <= len(b); n++ {
+			rar4Passworded(b[:n])
+			rar5Passworded(b[:n])
+		}
+		corrupt := append([]byte{}, b...)
+		for i := range corrupt {
+			corrupt[i] = 0xff
+			rar4Passworded(corrupt)
+			rar5Passworded(corrupt)
+		}
+	}
+	if v, p := vint([]byte{0x80, 0x80}, 0); v != 0 || p != -1 {
+		t.Fatalf("unterminated vint decoded to %d at %d", v, p)
+	}
+	if v, p := vint([]byte{0xac, 0x02}, 0); v != 300 || p != 2 {
+		t.Fatalf("vint decoded to %d at %d, expected 300 at 2", v, p)
+	}
+}
+
+func write(t *testing.T, dir string, name string, b []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPassworded(t *testing.T) {
+	dir := t.TempDir()
+	plain := write(t, dir, "plain.rar", rar5Plain)
+	locked := write(t, dir, "locked.rar", rar4Locked)
+
+	if err := Archives([]string{plain, write(t, dir, "a.mkv", nil)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Archives([]string{plain, locked}); err == nil {
+		t.Fatal("passworded archive accepted")
+	}
+}
+
+func TestContainer(t *testing.T) {
+	ts := make([]byte, 189)
+	ts[0], ts[188] = 0x47, 0x47
+	m2ts := []byte{0, 0, 0, 0, 0x47}
+
+	for name, b := range map[string][]byte{
+		"a.mkv":  {0x1a, 0x45, 0xdf, 0xa3, 0},
+		"a.webm": {0x1a, 0x45, 0xdf, 0xa3},
+		"a.mp4":  []byte("\x00\x00\x00\x20ftypisom"),
+		"a.m4v":  []byte("\x00\x00\x00\x08free"),
+		"a.mov":  []byte("\x00\x00\x00\x08moov"),
+		"a.avi":  []byte("RIFF\x00\x00\x00\x00AVI LIST"),
+		"a.wmv":  {0x30, 0x26, 0xb2, 0x75, 0x8e},
+		"a.ts":   ts,
+		"a.m2ts": m2ts,
+	} {
+		path := write(t, t.TempDir(), name, b)
+		if err := container(path); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		// the magic of another container is refused
+		fake := write(t, t.TempDir(), name, []byte("MZ\x90\x00 not a video at all"))
+		if err := container(fake); err == nil {
+			t.Fatalf("%s: executable taken for a video", name)
+		}
+		if err := container(write(t, t.TempDir(), name, b[:1])); err == nil {
+			t.Fatalf("%s: truncated header taken for a video", name)
+		}
+	}
+}
+
+// video writes a sparse mkv of size bytes
+func video(t *testing.T, dir string, name string, size int64) string {
+	path := write(t, dir, name, []byte{0x1a, 0x45, 0xdf, 0xa3})
+	if err := os.Truncate(path, size); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	runtime := 40 * time.Minute
+	min := int64(runtime.Seconds()) * minBitrate
+
+	big := video(t, dir, "big.mkv", min)
+	small := video(t, dir, "small.mkv", min-1)
+	sample := video(t, dir, "sample.mkv", 1024)
+	rar := write(t, dir, "a.rar", rar5Plain)
+	exe := write(t, dir, "a.exe", nil)
+
+	for name, c := range map[string]struct {
+		paths   []string
+		runtime time.Duration
+		ok      bool
+	}{
+		"at threshold":          {[]string{big, sample}, runtime, true},
+		"under threshold":       {[]string{small, sample}, runtime, false},
+		"unknown runtime":       {[]string{sample}, 0, true},
+		"executable":            {[]string{big, exe}, runtime, false},
+		"archive without video": {[]string{rar}, runtime, false},
+		"nothing":               {nil, runtime, false},
+		"no video, no runtime":  {[]string{rar}, 0, true},
+	} {
+		err := Verify(c.paths, c.runtime)
+		if (err == nil) != c.ok {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+}
+
+/* vim: set ts=2: */